@@ -0,0 +1,9 @@
+// sider2api 服务入口. 部署目标由 SIDER2API_PROFILE (origin/hf/socks) 选择,
+// 其余配置项见 core/config.go.
+package main
+
+import "sider2api/core"
+
+func main() {
+	core.Run(core.LoadConfig(""))
+}
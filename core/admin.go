@@ -0,0 +1,422 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed web/admin.html
+var adminPage []byte
+
+// adminPageHandler 返回内嵌的管理界面, 页面本身不需要认证, 其中的数据通过管理接口加载
+func (s *Server) adminPageHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(adminPage)
+}
+
+// adminAuth 校验管理令牌 ADMIN_TOKEN (与客户端 Key 一样通过 Authorization: Bearer 或 x-api-key 传递),
+// 未配置 ADMIN_TOKEN 时管理接口不可用
+func (s *Server) adminAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminToken == "" {
+			writeOpenAIError(w, http.StatusForbidden, "permission_error", "未配置 ADMIN_TOKEN, 管理接口已禁用", "admin_disabled")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(clientToken(r)), []byte(s.cfg.AdminToken)) != 1 {
+			writeOpenAIError(w, http.StatusUnauthorized, "authentication_error", "管理令牌无效", "invalid_admin_token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// adminHandler 处理 /api/admin/{models|keys|tokens}[/{id}]
+func (s *Server) adminHandler(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, s.cfg.RoutePrefix+"/api/admin/"), "/")
+	resource, id, _ := strings.Cut(path, "/")
+
+	switch resource {
+	case "models":
+		s.adminModels(w, r, id)
+	case "keys":
+		s.adminKeys(w, r, id)
+	case "tokens":
+		s.adminTokens(w, r, id)
+	case "stats":
+		s.adminStats(w, r)
+	default:
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error", "未知的管理接口: "+r.URL.Path, "not_found")
+	}
+}
+
+// adminStats 返回请求统计、账号状态与最近错误
+func (s *Server) adminStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeAdminError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+		return
+	}
+	snap := s.stats.snapshot()
+	snap.Accounts = s.tokens.Status()
+	snap.Status = healthOf(snap.Accounts)
+	snap.Sessions = s.sessions.Len()
+	writeAdminJSON(w, http.StatusOK, snap)
+}
+
+// adminResult 是修改类管理接口的响应
+type adminResult struct {
+	Data      interface{} `json:"data"`
+	Persisted bool        `json:"persisted"` // 为 false 时修改只在内存中生效, 重启后丢失
+}
+
+// adminModels 管理模型注册表, 修改保存到 MODELS_FILE
+func (s *Server) adminModels(w http.ResponseWriter, r *http.Request, id string) {
+	switch {
+	case r.Method == "GET" && id == "":
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": s.models.List()})
+
+	case r.Method == "GET":
+		m, ok := s.models.Resolve(id)
+		if !ok || m.ID != id {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("模型 %s 不存在", id))
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, m)
+
+	case r.Method == "POST" && id == "":
+		var m ModelInfo
+		if !readAdminBody(w, r, &m) {
+			return
+		}
+		if err := s.models.Put(m, true); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		m, _ = s.models.Resolve(m.ID)
+		fmt.Printf("管理接口: 新增模型 %s\n", m.ID)
+		s.adminSaved(w, http.StatusCreated, m, s.cfg.ModelsFile, s.models.Save)
+
+	case r.Method == "PUT":
+		body, ok := readAdminRaw(w, r)
+		if !ok {
+			return
+		}
+		if id == "" {
+			// 兼容 admin.html: PUT /api/admin/models, id 在请求体中
+			var probe struct {
+				ID string `json:"id"`
+			}
+			json.Unmarshal(body, &probe)
+			id = probe.ID
+		}
+		m, ok := s.models.Resolve(id)
+		if !ok || m.ID != id {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("模型 %s 不存在", id))
+			return
+		}
+		// 在现有配置上覆盖请求体中给出的字段; 旧格式的 model 字段视为 upstream
+		var probe struct {
+			Upstream *string `json:"upstream"`
+		}
+		json.Unmarshal(body, &probe)
+		if err := json.Unmarshal(body, &m); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "解析请求失败: "+err.Error())
+			return
+		}
+		if m.ID != id {
+			writeAdminError(w, http.StatusBadRequest, "不能修改模型 id, 请删除后重新添加")
+			return
+		}
+		if m.Model != "" && probe.Upstream == nil {
+			m.Upstream = m.Model
+		}
+		if err := s.models.Put(m, false); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		m, _ = s.models.Resolve(id)
+		fmt.Printf("管理接口: 修改模型 %s\n", id)
+		s.adminSaved(w, http.StatusOK, m, s.cfg.ModelsFile, s.models.Save)
+
+	case r.Method == "DELETE" && id != "":
+		if m, ok := s.models.Resolve(s.cfg.DefaultModel); ok && m.ID == id {
+			writeAdminError(w, http.StatusBadRequest, fmt.Sprintf("模型 %s 是 DEFAULT_MODEL, 不能删除", id))
+			return
+		}
+		if err := s.models.Delete(id); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fmt.Printf("管理接口: 删除模型 %s\n", id)
+		s.adminSaved(w, http.StatusOK, map[string]string{"id": id}, s.cfg.ModelsFile, s.models.Save)
+
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+	}
+}
+
+// keyView 是管理接口展示的 Key: 列表中只显示 key 的首尾几位
+type keyView struct {
+	APIKey
+	Key        string `json:"key,omitempty"` // 仅在创建时返回完整 key
+	KeyPreview string `json:"key_preview"`
+	Source     string `json:"source"` // env / file
+}
+
+func newKeyView(k APIKey, full bool) keyView {
+	v := keyView{APIKey: k, KeyPreview: maskSecret(k.Key), Source: "file"}
+	if full {
+		v.Key = k.Key
+	}
+	if k.FromEnv() {
+		v.Source = "env"
+	}
+	return v
+}
+
+// maskSecret 只保留首尾几位
+func maskSecret(secret string) string {
+	if len(secret) <= 10 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:6] + "..." + secret[len(secret)-4:]
+}
+
+// adminKeys 管理客户端 Key (按名称), 修改保存到 KEYS_FILE
+func (s *Server) adminKeys(w http.ResponseWriter, r *http.Request, name string) {
+	find := func() (APIKey, bool) {
+		for _, k := range s.keys.List() {
+			if k.Name == name {
+				return k, true
+			}
+		}
+		return APIKey{}, false
+	}
+
+	switch {
+	case r.Method == "GET" && name == "":
+		keys := s.keys.List()
+		views := make([]keyView, len(keys))
+		for i, k := range keys {
+			views[i] = newKeyView(k, false)
+		}
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": views})
+
+	case r.Method == "GET":
+		k, ok := find()
+		if !ok {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("Key %s 不存在", name))
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, newKeyView(k, false))
+
+	case r.Method == "POST" && name == "":
+		var k APIKey
+		if !readAdminBody(w, r, &k) {
+			return
+		}
+		k, err := s.keys.Create(k)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fmt.Printf("管理接口: 新增 Key %s\n", k.Name)
+		s.adminSaved(w, http.StatusCreated, newKeyView(k, true), s.cfg.KeysFile, s.keys.Save)
+
+	case r.Method == "PUT" && name != "":
+		k, ok := find()
+		if !ok {
+			writeAdminError(w, http.StatusNotFound, fmt.Sprintf("Key %s 不存在", name))
+			return
+		}
+		oldKey := k.Key
+		body, ok := readAdminRaw(w, r)
+		if !ok {
+			return
+		}
+		// 请求体中明确给出 used 时才修改已用次数 (例如清零), 否则保留当前计数
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(body, &fields); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "解析请求失败: "+err.Error())
+			return
+		}
+		if err := json.Unmarshal(body, &k); err != nil {
+			writeAdminError(w, http.StatusBadRequest, "解析请求失败: "+err.Error())
+			return
+		}
+		_, setUsed := fields["used"]
+		k, err := s.keys.Update(name, k, setUsed)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.limiter.Forget(oldKey)
+		fmt.Printf("管理接口: 修改 Key %s\n", name)
+		s.adminSaved(w, http.StatusOK, newKeyView(k, k.Key != oldKey), s.cfg.KeysFile, s.keys.Save)
+
+	case r.Method == "DELETE" && name != "":
+		k, err := s.keys.Delete(name)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.limiter.Forget(k.Key)
+		fmt.Printf("管理接口: 删除 Key %s\n", name)
+		s.adminSaved(w, http.StatusOK, map[string]string{"name": name}, s.cfg.KeysFile, s.keys.Save)
+
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+	}
+}
+
+// adminTokens 管理上游 Sider 账号 (按账号名 tok-xxxxxxxx), 修改保存到 TOKENS_FILE
+func (s *Server) adminTokens(w http.ResponseWriter, r *http.Request, name string) {
+	var req tokenFileEntry
+
+	switch {
+	case r.Method == "GET" && name == "":
+		writeAdminJSON(w, http.StatusOK, map[string]interface{}{"object": "list", "data": s.tokens.Status()})
+
+	case r.Method == "GET":
+		for _, st := range s.tokens.Status() {
+			if st.Name == name {
+				writeAdminJSON(w, http.StatusOK, st)
+				return
+			}
+		}
+		writeAdminError(w, http.StatusNotFound, fmt.Sprintf("账号 %s 不存在", name))
+
+	case r.Method == "POST" && name == "":
+		if !readAdminBody(w, r, &req) {
+			return
+		}
+		st, err := s.tokens.Add(req.Token, req.Weight)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.adminSaved(w, http.StatusCreated, st, s.cfg.TokensFile, s.tokens.Save)
+
+	case r.Method == "PUT" && name != "":
+		if !readAdminBody(w, r, &req) {
+			return
+		}
+		st, err := s.tokens.SetWeight(name, req.Weight)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		fmt.Printf("管理接口: 账号 %s 权重改为 %d\n", name, st.Weight)
+		s.adminSaved(w, http.StatusOK, st, s.cfg.TokensFile, s.tokens.Save)
+
+	case r.Method == "DELETE" && name != "":
+		if err := s.tokens.Remove(name); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		s.adminSaved(w, http.StatusOK, map[string]string{"name": name}, s.cfg.TokensFile, s.tokens.Save)
+
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "不支持的请求方法")
+	}
+}
+
+// adminSaved 将修改保存到 path 并返回结果. path 为空时修改只在内存中生效
+func (s *Server) adminSaved(w http.ResponseWriter, status int, data interface{}, path string, save func(string) error) {
+	if path == "" {
+		writeAdminJSON(w, status, adminResult{Data: data})
+		return
+	}
+	if err := save(path); err != nil {
+		fmt.Printf("保存 %s 失败: %v\n", path, err)
+		writeAdminError(w, http.StatusInternalServerError, fmt.Sprintf("修改已生效, 但保存到 %s 失败: %v", path, err))
+		return
+	}
+	writeAdminJSON(w, status, adminResult{Data: data, Persisted: true})
+}
+
+func readAdminRaw(w http.ResponseWriter, r *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		writeAdminError(w, http.StatusBadRequest, "读取请求体失败")
+		return nil, false
+	}
+	return body, true
+}
+
+// readAdminBody 将请求体解析到 v 上, 只覆盖请求体中出现的字段
+func readAdminBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	body, ok := readAdminRaw(w, r)
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "解析请求失败: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, status int, message string) {
+	writeOpenAIError(w, status, "invalid_request_error", message, "")
+}
+
+// writeJSONFile 原子地写入 JSON 文件: 先写同目录下的临时文件, 再重命名覆盖,
+// 进程中途退出也不会留下写了一半的文件
+func writeJSONFile(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // 重命名成功后为空操作
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// randomHex 返回 n 字节随机数的十六进制表示
+func randomHex(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
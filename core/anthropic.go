@@ -0,0 +1,352 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Anthropic Messages API 请求结构
+type AnthropicRequest struct {
+	Model     string             `json:"model"`
+	System    json.RawMessage    `json:"system"` // 字符串或 [{type:"text",text:"..."}]
+	Messages  []AnthropicMessage `json:"messages"`
+	MaxTokens int                `json:"max_tokens"`
+	Stream    bool               `json:"stream"`
+	Thinking  *AnthropicThinking `json:"thinking"`
+}
+
+// extended thinking 配置, type 为 enabled / disabled
+type AnthropicThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens"`
+}
+
+type AnthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // 字符串或内容块数组
+}
+
+// Anthropic 内容块, 只保留转换需要的字段
+type AnthropicContentBlock struct {
+	Type    string                `json:"type"`
+	Text    string                `json:"text"`
+	Content json.RawMessage       `json:"content,omitempty"` // tool_result 的内容
+	Source  *AnthropicImageSource `json:"source,omitempty"`  // image 块的图片
+}
+
+// image 块的来源, type 为 base64 或 url
+type AnthropicImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// Anthropic 思考内容块
+type AnthropicThinkingBlock struct {
+	Type      string `json:"type"`
+	Thinking  string `json:"thinking"`
+	Signature string `json:"signature"`
+}
+
+// Anthropic 非流式响应结构, Content 为 AnthropicThinkingBlock 与 AnthropicContentBlock
+type AnthropicResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []interface{}  `json:"content"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        AnthropicUsage `json:"usage"`
+}
+
+type AnthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// Anthropic 错误响应结构
+type AnthropicError struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// anthropicText 将字符串或内容块数组形式的 content 展开为纯文本
+func anthropicText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return ""
+	}
+	var parts []string
+	for _, b := range blocks {
+		switch b.Type {
+		case "text":
+			parts = append(parts, b.Text)
+		case "tool_result":
+			parts = append(parts, anthropicText(b.Content))
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// anthropicImages 返回内容块数组中 image 块的图片地址
+func anthropicImages(raw json.RawMessage) []string {
+	var blocks []AnthropicContentBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil
+	}
+	var images []string
+	for _, b := range blocks {
+		if b.Type != "image" || b.Source == nil {
+			continue
+		}
+		switch b.Source.Type {
+		case "base64":
+			images = append(images, dataURL(b.Source.MediaType, b.Source.Data))
+		case "url":
+			images = append(images, b.Source.URL)
+		}
+	}
+	return images
+}
+
+// toUserRequest 将 Anthropic 请求转换为内部统一的 UserRequest
+func (req *AnthropicRequest) toUserRequest() *UserRequest {
+	userReq := &UserRequest{
+		Model:     req.Model,
+		Stream:    req.Stream,
+		MaxTokens: req.MaxTokens,
+	}
+	if req.Thinking != nil {
+		userReq.ReasoningEffort = "medium"
+		if req.Thinking.Type == "disabled" {
+			userReq.ReasoningEffort = "none"
+		}
+	}
+	if system := anthropicText(req.System); strings.TrimSpace(system) != "" {
+		userReq.Messages = append(userReq.Messages, Message{Role: "system", Content: system})
+	}
+	for _, m := range req.Messages {
+		role := "user"
+		if m.Role == "assistant" {
+			role = "assistant"
+		}
+		userReq.Messages = append(userReq.Messages, Message{Role: role, Content: anthropicText(m.Content), Images: anthropicImages(m.Content)})
+	}
+	return userReq
+}
+
+func writeAnthropicError(w http.ResponseWriter, status int, errType, message string) {
+	var resp AnthropicError
+	resp.Type = "error"
+	resp.Error.Type = errType
+	resp.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// anthropicErrorType 将内部错误类型映射为 Anthropic 的错误类型
+func anthropicErrorType(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	}
+	return "api_error"
+}
+
+func anthropicStopReason(finishReason string) string {
+	if finishReason == "length" {
+		return "max_tokens"
+	}
+	return "end_turn"
+}
+
+// anthropicMessagesHandler 处理 POST /v1/messages (非流式 + 流式)
+func (s *Server) anthropicMessagesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "读取请求失败")
+		return
+	}
+	defer r.Body.Close()
+
+	var req AnthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Printf("解析请求体失败: %v\n", err)
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "解析请求失败")
+		return
+	}
+	if len(req.Messages) == 0 {
+		writeAnthropicError(w, http.StatusBadRequest, "invalid_request_error", "messages: field required")
+		return
+	}
+
+	c, apiErr := s.startCompletion(r, req.toUserRequest())
+	if apiErr != nil {
+		setErrorHeaders(w, apiErr)
+		writeAnthropicError(w, apiErr.Status, anthropicErrorType(apiErr.Status), apiErr.Message)
+		return
+	}
+	defer c.finish()
+
+	msgID := fmt.Sprintf("msg_%d", time.Now().UnixNano())
+	model := req.Model
+	if model == "" || c.FellBack {
+		model = c.Model
+	}
+	c.setHeaders(w)
+
+	if !req.Stream || !c.Stream {
+		if err := c.each(func(SiderEvent) bool { return true }); err != nil {
+			fmt.Printf("读取响应失败: %v\n", err)
+			apiErr := toAPIError(err)
+			setErrorHeaders(w, apiErr)
+			writeAnthropicError(w, apiErr.Status, anthropicErrorType(apiErr.Status), apiErr.Message)
+			return
+		}
+		stopReason := anthropicStopReason(c.FinishReason())
+		var content []interface{}
+		if reasoning := c.Reasoning(); reasoning != "" {
+			content = append(content, AnthropicThinkingBlock{Type: "thinking", Thinking: reasoning})
+		}
+		content = append(content, AnthropicContentBlock{Type: "text", Text: c.Text()})
+		resp := AnthropicResponse{
+			ID:         msgID,
+			Type:       "message",
+			Role:       "assistant",
+			Model:      model,
+			Content:    content,
+			StopReason: &stopReason,
+			Usage: AnthropicUsage{
+				InputTokens:  c.PromptTokens(),
+				OutputTokens: c.CompletionTokens(),
+			},
+		}
+		w.Header().Set("X-Conversation-ID", c.ConversationID())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// 流式: 按 Anthropic SSE 事件序列输出
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	sendEvent := func(event string, data interface{}) bool {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			fmt.Printf("转换Anthropic格式失败: %v\n", err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			fmt.Printf("写入响应失败: %v\n", err)
+			return false
+		}
+		flush(w)
+		return true
+	}
+
+	sendEvent("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": AnthropicResponse{
+			ID:      msgID,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   model,
+			Content: []interface{}{},
+			Usage:   AnthropicUsage{InputTokens: c.PromptTokens()},
+		},
+	})
+	sendEvent("ping", map[string]string{"type": "ping"})
+
+	// 思考过程与正文分属不同的内容块, 类型切换时关闭上一个块再开启新块
+	index, current := -1, ""
+	startBlock := func(kind string) bool {
+		if kind == current {
+			return true
+		}
+		if current != "" {
+			sendEvent("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+		}
+		index++
+		current = kind
+		var block interface{} = AnthropicContentBlock{Type: "text", Text: ""}
+		if kind == "thinking" {
+			block = AnthropicThinkingBlock{Type: "thinking", Thinking: ""}
+		}
+		return sendEvent("content_block_start", map[string]interface{}{
+			"type":          "content_block_start",
+			"index":         index,
+			"content_block": block,
+		})
+	}
+	sendDelta := func(delta map[string]string) bool {
+		return sendEvent("content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": delta,
+		})
+	}
+
+	err = c.each(func(ev SiderEvent) bool {
+		if ev.Type == EventPulse {
+			return sendEvent("ping", map[string]string{"type": "ping"})
+		}
+		if reasoning := ev.Reasoning(); reasoning != "" {
+			return startBlock("thinking") && sendDelta(map[string]string{"type": "thinking_delta", "thinking": reasoning})
+		}
+		text := ev.Content()
+		if text == "" {
+			return true
+		}
+		return startBlock("text") && sendDelta(map[string]string{"type": "text_delta", "text": text})
+	})
+	if err != nil {
+		fmt.Printf("读取响应失败: %v\n", err)
+		apiErr := toAPIError(err)
+		sendEvent("error", map[string]interface{}{
+			"type":  "error",
+			"error": map[string]string{"type": anthropicErrorType(apiErr.Status), "message": apiErr.Message},
+		})
+		return
+	}
+
+	if current != "text" {
+		startBlock("text")
+	}
+	sendEvent("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+	sendEvent("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": anthropicStopReason(c.FinishReason()), "stop_sequence": nil},
+		"usage": map[string]int{"output_tokens": c.CompletionTokens()},
+	})
+	sendEvent("message_stop", map[string]string{"type": "message_stop"})
+}
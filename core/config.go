@@ -0,0 +1,281 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 部署目标预设. 各目标之间的差异(监听地址/路由前缀/认证/代理/强制非流式)
+// 全部由 Config 决定, 预设只负责给出默认值, 环境变量始终可以覆盖.
+const (
+	ProfileOrigin = "origin" // linux terminal 直接运行
+	ProfileHF     = "hf"     // huggingface space, 路由前缀 /hf, 强制认证
+	ProfileSocks  = "socks"  // 通过 SOCKS5 代理访问 Sider
+	ProfileVercel = "vercel" // vercel serverless, 强制非流式
+)
+
+const defaultSiderURL = "https://api2.sider.ai/api/v3/completion/text"
+
+// 配置结构
+type Config struct {
+	Profile string
+
+	Host        string
+	Port        string
+	RoutePrefix string // 例如 "/hf", 路由注册为 RoutePrefix+"/v1/..."
+
+	AuthToken   string   // 客户端访问本服务的 API Key
+	APIKeys     []string // 更多客户端 Key, 每项为 key 或 name=key
+	KeysFile    string   // 带元数据 (允许的模型/过期时间/启用/配额) 的 Key 文件, 管理接口的修改也写入此文件
+	RequireAuth bool     // 为 true 时未配置任何 Key 直接拒绝请求
+	AdminToken  string   // 管理接口 /api/admin/* 的令牌, 为空时禁用管理接口
+
+	SiderURL       string
+	SiderToken     string
+	DefaultModel   string
+	ModelsFile     string   // 模型注册表 (JSON), 文件不存在或为空时使用内置模型
+	ModelAliases   []string // 额外的别名, 每项为 alias=model
+	ModelFallbacks []string // 模型回退链, 每项为 model=a|b
+
+	// /v1/images/generations 未指定 model 时使用的模型, 以及图片生成的超时时间 (图片模型通常比对话慢得多)
+	ImageModel   string
+	ImageTimeout time.Duration
+
+	// 上游账号池: 每项为 token 或 token:weight, 已包含 SiderToken
+	SiderTokens   []string
+	TokensFile    string        // 通过管理接口添加的账号保存在此文件
+	TokenStrategy string        // round-robin / least-used / weighted
+	TokenCooldown time.Duration // 账号认证失败或被限流后暂停使用的最短时长
+	TokenCheck    time.Duration // 定期检查 token 有效期的间隔, 为 0 时只在启动时检查
+
+	// 限流: 每分钟请求数与估算 token 数, 0 表示不限制. Key 级限额可在 KEYS_FILE 中按 Key 覆盖
+	RateLimitRPM    int
+	RateLimitTPM    int
+	KeyRateLimitRPM int
+	KeyRateLimitTPM int
+
+	// Sider 对单次输入的上限: 约 50k 字符, 词数过多时返回 code:603
+	MaxPromptChars int
+	MaxPromptWords int
+
+	// 对话超出字符/词数/上下文窗口预算时的处理: drop_oldest / middle_out / summarize,
+	// summarize 使用 SummaryModel 将较早的轮次压缩为摘要
+	ContextStrategy string
+	SummaryModel    string
+
+	// tiktoken 词表目录 (cl100k_base.tiktoken / o200k_base.tiktoken), 优先于内嵌词表
+	TokenizerDir string
+
+	// 闲置超过该时长的 Sider 会话将被清理, 为 0 时不复用会话
+	SessionTTL time.Duration
+
+	// 模拟函数调用时, 模型输出的调用无法解析的处理方式: text / error
+	ToolFallback string
+	// response_format 要求 JSON 时, 回复校验失败后要求模型修正的最多次数
+	JSONRepairRetries int
+
+	ProxyAddr     string
+	ProxyPort     string
+	ProxyUser     string
+	ProxyPassword string
+
+	ForceNonStream bool // vercel 免费版有 60s 限制, 流式输出会被截断
+}
+
+// LoadConfig 按部署预设加载配置: 先读取 .env (不覆盖已有环境变量), 再用环境变量覆盖预设默认值
+func LoadConfig(profile string) Config {
+	if err := loadDotEnv(".env"); err == nil {
+		fmt.Println("已加载 .env 文件")
+	}
+
+	if profile == "" {
+		profile = getEnv("SIDER2API_PROFILE", ProfileOrigin)
+	}
+
+	cfg := Config{
+		Profile:      profile,
+		Host:         "127.0.0.1",
+		Port:         "7055",
+		SiderURL:     defaultSiderURL,
+		DefaultModel: "gpt-4o",
+		ImageModel:   "dalle_3_HD",
+		ImageTimeout: 5 * time.Minute,
+		ModelsFile:   "custom_models.json",
+		KeysFile:     "keys.json",
+		TokensFile:   "tokens.json",
+
+		MaxPromptChars: 49500,
+		MaxPromptWords: 6000,
+
+		ContextStrategy: ContextDropOldest,
+		SummaryModel:    "gpt-4.1-mini",
+
+		SessionTTL: time.Hour,
+
+		ToolFallback:      ToolFallbackText,
+		JSONRepairRetries: 2,
+
+		TokenStrategy: StrategyRoundRobin,
+		TokenCooldown: 5 * time.Minute,
+		TokenCheck:    time.Hour,
+	}
+
+	switch profile {
+	case ProfileHF:
+		cfg.Host = "0.0.0.0"
+		cfg.RoutePrefix = "/hf"
+		cfg.RequireAuth = true
+	case ProfileVercel:
+		cfg.ForceNonStream = true
+		// vercel 的文件系统只读, 管理接口的修改只在当前实例内生效, 不写文件
+		cfg.ModelsFile = ""
+		cfg.KeysFile = ""
+		cfg.TokensFile = ""
+	}
+
+	cfg.Host = getEnv("HOST", cfg.Host)
+	cfg.Port = getEnv("PORT", cfg.Port)
+	cfg.RoutePrefix = strings.TrimRight(getEnv("ROUTE_PREFIX", cfg.RoutePrefix), "/")
+	cfg.AuthToken = os.Getenv("AUTH_TOKEN")
+	cfg.APIKeys = splitList(os.Getenv("API_KEYS"))
+	cfg.KeysFile = getEnv("KEYS_FILE", cfg.KeysFile)
+	cfg.AdminToken = os.Getenv("ADMIN_TOKEN")
+	cfg.RequireAuth = getEnvBool("REQUIRE_AUTH", cfg.RequireAuth)
+
+	// 历史上各版本使用了不同的变量名, 这里全部兼容
+	cfg.SiderURL = firstEnv(cfg.SiderURL, "SIDER_API_URL", "SIDER_URL")
+	cfg.SiderToken = firstEnv("", "SIDER_AUTH_TOKEN", "SIDER_TOKEN", "SIDER_AUTHORIZATION_KEY")
+	cfg.SiderTokens = splitList(os.Getenv("SIDER_AUTH_TOKENS"))
+	if cfg.SiderToken != "" {
+		cfg.SiderTokens = append([]string{cfg.SiderToken}, cfg.SiderTokens...)
+	} else if len(cfg.SiderTokens) > 0 {
+		cfg.SiderToken, _ = parseTokenEntry(cfg.SiderTokens[0])
+	}
+	cfg.TokensFile = getEnv("TOKENS_FILE", cfg.TokensFile)
+	cfg.TokenStrategy = getEnv("TOKEN_STRATEGY", cfg.TokenStrategy)
+	cfg.TokenCooldown = getEnvDuration("TOKEN_COOLDOWN", cfg.TokenCooldown)
+	cfg.TokenCheck = getEnvDuration("TOKEN_CHECK_INTERVAL", cfg.TokenCheck)
+	cfg.RateLimitRPM = getEnvInt("RATE_LIMIT_RPM", cfg.RateLimitRPM)
+	cfg.RateLimitTPM = getEnvInt("RATE_LIMIT_TPM", cfg.RateLimitTPM)
+	cfg.KeyRateLimitRPM = getEnvInt("KEY_RATE_LIMIT_RPM", cfg.KeyRateLimitRPM)
+	cfg.KeyRateLimitTPM = getEnvInt("KEY_RATE_LIMIT_TPM", cfg.KeyRateLimitTPM)
+	cfg.DefaultModel = getEnv("DEFAULT_MODEL", cfg.DefaultModel)
+	cfg.ImageModel = getEnv("IMAGE_MODEL", cfg.ImageModel)
+	cfg.ImageTimeout = getEnvDuration("IMAGE_TIMEOUT", cfg.ImageTimeout)
+	cfg.ModelsFile = getEnv("MODELS_FILE", cfg.ModelsFile)
+	cfg.ModelAliases = splitList(os.Getenv("MODEL_ALIASES"))
+	cfg.ModelFallbacks = splitList(os.Getenv("MODEL_FALLBACKS"))
+	cfg.MaxPromptChars = getEnvInt("MAX_PROMPT_CHARS", cfg.MaxPromptChars)
+	cfg.MaxPromptWords = getEnvInt("MAX_PROMPT_WORDS", cfg.MaxPromptWords)
+	cfg.ContextStrategy = getEnv("CONTEXT_STRATEGY", cfg.ContextStrategy)
+	cfg.SummaryModel = getEnv("SUMMARY_MODEL", cfg.SummaryModel)
+	cfg.TokenizerDir = os.Getenv("TOKENIZER_DIR")
+	cfg.SessionTTL = getEnvDuration("SESSION_TTL", cfg.SessionTTL)
+	cfg.ToolFallback = getEnv("TOOL_PARSE_FALLBACK", cfg.ToolFallback)
+	cfg.JSONRepairRetries = getEnvInt("JSON_REPAIR_RETRIES", cfg.JSONRepairRetries)
+
+	cfg.ProxyAddr = os.Getenv("PROXY_ADDR")
+	cfg.ProxyPort = os.Getenv("PROXY_PORT")
+	cfg.ProxyUser = os.Getenv("PROXY_USER")
+	cfg.ProxyPassword = os.Getenv("PROXY_PASSWORD")
+
+	if profile == ProfileVercel || os.Getenv("VERCEL") != "" {
+		cfg.ForceNonStream = true
+	}
+	cfg.ForceNonStream = getEnvBool("FORCE_NON_STREAM", cfg.ForceNonStream)
+
+	return cfg
+}
+
+// ListenAddr 返回监听地址 host:port
+func (c Config) ListenAddr() string {
+	return fmt.Sprintf("%s:%s", c.Host, c.Port)
+}
+
+// 获取环境变量，如果不存在则返回默认值
+func getEnv(key, defaultValue string) string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// 按顺序返回第一个非空的环境变量
+func firstEnv(defaultValue string, keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return defaultValue
+}
+
+func getEnvBool(key string, defaultValue bool) bool {
+	switch strings.ToLower(os.Getenv(key)) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return defaultValue
+}
+
+func getEnvInt(key string, defaultValue int) int {
+	value, err := strconv.Atoi(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(key))
+	if err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// splitList 按逗号或换行拆分列表, 忽略空项
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// loadDotEnv 读取 KEY=VALUE 格式的 .env 文件, 已存在的环境变量不会被覆盖
+func loadDotEnv(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		value = strings.Trim(strings.TrimSpace(value), `"'`)
+		if _, exists := os.LookupEnv(key); !exists {
+			os.Setenv(key, value)
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,241 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// 上下文管理策略 (CONTEXT_STRATEGY). 新建会话时发送完整历史, 拼接后超出模型的输入预算时按策略处理,
+// 实际使用的策略通过 X-Context-Strategy 响应头返回. buildPrompt 的截断仍作为最后的兜底.
+const (
+	ContextDropOldest = "drop_oldest" // 从最早的轮次开始丢弃, 保留 system
+	ContextMiddleOut  = "middle_out"  // 保留开头与最近的轮次, 从中间开始丢弃; 仍超出时截去最长消息的中间部分
+	ContextSummarize  = "summarize"   // 用 SUMMARY_MODEL 将较早的轮次压缩为摘要, 失败时退回 drop_oldest
+)
+
+const truncatedMarker = "\n\n[... truncated ...]\n\n"
+
+// contextBudget 是模型的输入预算, 为 0 的项不限制
+type contextBudget struct {
+	chars  int // MAX_PROMPT_CHARS
+	words  int // MAX_PROMPT_WORDS
+	tokens int // 模型上下文窗口减去 max_tokens
+	tok    Tokenizer
+}
+
+func (s *Server) contextBudget(info ModelInfo, maxTokens int) contextBudget {
+	b := contextBudget{chars: s.cfg.MaxPromptChars, words: s.cfg.MaxPromptWords, tok: s.tokenizers.For(info)}
+	if info.ContextLength > 0 && maxTokens < info.ContextLength {
+		b.tokens = info.ContextLength - maxTokens
+	}
+	return b
+}
+
+// ratio 返回文本用量与预算之比中最大的一项, 不超出预算时不大于 1
+func (b contextBudget) ratio(text string) float64 {
+	ratio := 0.0
+	check := func(used, limit int) {
+		if limit > 0 && float64(used)/float64(limit) > ratio {
+			ratio = float64(used) / float64(limit)
+		}
+	}
+	check(utf8.RuneCountInString(text), b.chars)
+	check(estimateWordCount(text), b.words)
+	if b.tokens > 0 {
+		check(b.tok.Count(text), b.tokens)
+	}
+	return ratio
+}
+
+// usage 返回对话拼接后的用量之比. 多算一个截断标题, 与 buildPrompt 的预算计算保持一致
+func (b contextBudget) usage(messages []Message) float64 {
+	prompt, _ := buildPrompt(messages, 0, 0)
+	return b.ratio(prompt + partialLabel)
+}
+
+func (b contextBudget) fits(messages []Message) bool {
+	return b.usage(messages) <= 1
+}
+
+// fitContext 在对话超出模型预算时按 CONTEXT_STRATEGY 处理, 返回处理后的消息与使用的策略, 未超出时策略为空.
+// system 与最后一条消息始终保留, 处理后仍超出时上游可能以 603 拒绝.
+func (s *Server) fitContext(r *http.Request, info ModelInfo, userReq *UserRequest, messages []Message) ([]Message, string) {
+	budget := s.contextBudget(info, userReq.MaxTokens)
+	if budget.fits(messages) {
+		return messages, ""
+	}
+
+	strategy := s.cfg.ContextStrategy
+	var fitted []Message
+	if strategy == ContextSummarize {
+		var err error
+		if fitted, err = s.summarize(r, budget, messages); err != nil {
+			fmt.Printf("摘要较早的对话失败, 改为丢弃最早的轮次: %v\n", err)
+			strategy = ContextDropOldest
+		}
+	}
+	if fitted == nil {
+		strategy, fitted = trimContext(budget, messages, strategy)
+	}
+	fmt.Printf("对话超出模型 %s 的输入预算, 已按 %s 处理: %d 条消息 -> %d 条\n", info.ID, strategy, len(messages), len(fitted))
+	return fitted, strategy
+}
+
+// trimContext 按不需要请求上游的策略处理对话, 返回实际使用的策略 (summarize 与未知策略按 drop_oldest)
+func trimContext(budget contextBudget, messages []Message, strategy string) (string, []Message) {
+	system, turns := splitSystem(messages)
+	if strategy == ContextMiddleOut {
+		return ContextMiddleOut, middleOut(budget, system, turns)
+	}
+	return ContextDropOldest, dropOldest(budget, system, turns)
+}
+
+// splitSystem 将 system / developer 消息与对话轮次分开, 拼接 prompt 时前者总是放在最前面
+func splitSystem(messages []Message) (system, turns []Message) {
+	for _, m := range messages {
+		if m.Role == "system" || m.Role == "developer" {
+			system = append(system, m)
+		} else {
+			turns = append(turns, m)
+		}
+	}
+	return system, turns
+}
+
+func joinMessages(parts ...[]Message) []Message {
+	var messages []Message
+	for _, p := range parts {
+		messages = append(messages, p...)
+	}
+	return messages
+}
+
+// dropOldest 从最早的轮次开始丢弃, 直到不超出预算或只剩最后一条
+func dropOldest(budget contextBudget, system, turns []Message) []Message {
+	for len(turns) > 1 && !budget.fits(joinMessages(system, turns)) {
+		turns = turns[1:]
+	}
+	return joinMessages(system, turns)
+}
+
+// middleOut 保留第一轮与最近的轮次, 从中间开始丢弃; 只剩首尾仍超出时, 反复截去最长消息的中间部分,
+// 直到不超出预算或所有消息都已不长于截断标记. 每轮至少缩短一条消息, 因此一定会结束
+func middleOut(budget contextBudget, system, turns []Message) []Message {
+	for len(turns) > 2 && !budget.fits(joinMessages(system, turns)) {
+		mid := len(turns) / 2
+		turns = append(turns[:mid:mid], turns[mid+1:]...)
+	}
+
+	messages := joinMessages(system, turns)
+	minimal := utf8.RuneCountInString(strings.TrimSpace(truncatedMarker))
+	for {
+		ratio := budget.usage(messages)
+		if ratio <= 1 {
+			break
+		}
+		longest, length := 0, 0
+		for j := range messages {
+			if n := utf8.RuneCountInString(messages[j].Content); n > length {
+				longest, length = j, n
+			}
+		}
+		if length <= minimal {
+			break // 已无法再缩短, 由上游决定是否接受
+		}
+		// 按整个 prompt 超出的比例估算需要从这条消息中去掉的字符数. 截断标记本身也占预算,
+		// 估算不足时下一轮继续截
+		prompt, _ := buildPrompt(messages, 0, 0)
+		excess := float64(utf8.RuneCountInString(prompt)) * (1 - 1/ratio) * 1.05
+		if float64(length)-excess <= 0 {
+			messages[longest].Content = strings.TrimSpace(truncatedMarker)
+			continue
+		}
+		messages[longest].Content = truncateMiddle(messages[longest].Content, float64(length)/(float64(length)-excess))
+	}
+	return messages
+}
+
+// truncateMiddle 将文本缩短为原来的 1/ratio, 保留开头与结尾
+func truncateMiddle(text string, ratio float64) string {
+	runes := []rune(text)
+	keep := int(float64(len(runes))/ratio) - utf8.RuneCountInString(truncatedMarker)
+	if keep <= 0 {
+		return strings.TrimSpace(truncatedMarker)
+	}
+	head := keep / 2
+	return string(runes[:head]) + truncatedMarker + string(runes[len(runes)-(keep-head):])
+}
+
+// summaryPrompt 是摘要请求的 system 提示
+const summaryPrompt = "Summarize the conversation below so that the summary can replace it as context for continuing the conversation. " +
+	"Keep facts, decisions, names, numbers, code identifiers and open questions; omit pleasantries. " +
+	"Write in the language of the conversation, at most %d words, and reply with the summary only."
+
+// summarize 保留预算一半以内的最近轮次, 将更早的轮次交给 SUMMARY_MODEL 压缩为摘要, 以 system 消息放在最前面.
+// 摘要请求属于同一次客户端请求, 不再计入限流、配额与请求数, 失败时记入错误统计.
+func (s *Server) summarize(r *http.Request, budget contextBudget, messages []Message) ([]Message, error) {
+	system, turns := splitSystem(messages)
+	half := contextBudget{chars: budget.chars / 2, words: budget.words / 2, tokens: budget.tokens / 2, tok: budget.tok}
+	start := 0
+	for start < len(turns)-1 && !half.fits(joinMessages(system, turns[start:])) {
+		start++
+	}
+	if start == 0 {
+		return nil, fmt.Errorf("没有可以摘要的历史")
+	}
+
+	words := 500
+	if budget.words > 0 && budget.words/5 < words {
+		words = budget.words / 5
+	}
+	instruction := fmt.Sprintf(summaryPrompt, words)
+	lines := make([]string, start)
+	for i, m := range turns[:start] {
+		lines[i] = fmt.Sprintf("%s: %s", roleLabel(m.Role), m.Content)
+	}
+	transcript := strings.Join(lines, "\n\n")
+
+	// 摘要请求同样受输入上限约束, 过长时截去中间部分
+	summaryBudget := budget
+	if info, ok := s.models.Resolve(s.cfg.SummaryModel); ok {
+		summaryBudget = s.contextBudget(info, 0)
+	}
+	for i := 0; i < 8; i++ {
+		ratio := summaryBudget.ratio(instruction + promptSeparator + transcript + partialLabel)
+		if ratio <= 1 {
+			break
+		}
+		transcript = truncateMiddle(transcript, ratio*1.05)
+	}
+	if transcript == strings.TrimSpace(truncatedMarker) {
+		return nil, fmt.Errorf("输入上限过小, 无法发送摘要请求")
+	}
+
+	c, apiErr := s.startCompletion(r, &UserRequest{
+		Model: s.cfg.SummaryModel,
+		Messages: []Message{
+			{Role: "system", Content: instruction},
+			{Role: "user", Content: transcript},
+		},
+		internal: true,
+		charged:  true,
+	})
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	err := c.each(func(SiderEvent) bool { return true })
+	c.finish()
+	if err != nil {
+		return nil, err
+	}
+	summary := strings.TrimSpace(c.Text())
+	if summary == "" {
+		return nil, fmt.Errorf("摘要为空")
+	}
+	fmt.Printf("已将 %d 条较早的消息摘要为 %d 字符 (模型: %s)\n", start, utf8.RuneCountInString(summary), c.Model)
+
+	note := Message{Role: "system", Content: "[Summary of Earlier Conversation]\n" + summary}
+	return dropOldest(budget, joinMessages(system, []Message{note}), turns[start:]), nil
+}
@@ -0,0 +1,143 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// promptChars 返回对话拼接后按 contextBudget.usage 计算的字符数
+func promptChars(messages []Message) int {
+	prompt, _ := buildPrompt(messages, 0, 0)
+	return utf8.RuneCountInString(prompt + partialLabel)
+}
+
+func testConversation() (system, turns []Message) {
+	system = []Message{{Role: "system", Content: "Be brief."}}
+	turns = []Message{
+		{Role: "user", Content: strings.Repeat("first question ", 20)},
+		{Role: "assistant", Content: strings.Repeat("a long answer 中文 ", 40)},
+		{Role: "user", Content: strings.Repeat("second ", 30)},
+		{Role: "assistant", Content: strings.Repeat("ok ", 10)},
+		{Role: "user", Content: strings.Repeat("latest question ", 25)},
+	}
+	return system, turns
+}
+
+// collapsed 表示所有消息都已不长于截断标记, 无法再缩短
+func collapsed(messages []Message) bool {
+	for _, m := range messages {
+		if utf8.RuneCountInString(m.Content) > utf8.RuneCountInString(strings.TrimSpace(truncatedMarker)) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestTruncateMiddle(t *testing.T) {
+	marker := utf8.RuneCountInString(truncatedMarker)
+	text := strings.Repeat("头", 50) + strings.Repeat("x", 100) + strings.Repeat("尾", 50)
+	tests := []struct {
+		name  string
+		ratio float64
+		want  int // 结果的字符数
+	}{
+		{name: "比例为 1 时长度不变", ratio: 1, want: 200},
+		{name: "缩短一半", ratio: 2, want: 100},
+		{name: "只够放下标记与首尾各一个字符", ratio: 200.0 / float64(marker+2), want: marker + 2},
+		{name: "放不下标记", ratio: 200.0 / float64(marker), want: len(strings.TrimSpace(truncatedMarker))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncateMiddle(text, tt.ratio)
+			if n := utf8.RuneCountInString(got); n != tt.want {
+				t.Errorf("长度 = %d, want %d", n, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("结果不是合法的 UTF-8: %q", got)
+			}
+			if tt.want > marker && (!strings.HasPrefix(got, "头") || !strings.HasSuffix(got, "尾") || !strings.Contains(got, truncatedMarker)) {
+				t.Errorf("应保留开头与结尾并插入标记: %q", got)
+			}
+		})
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	system, turns := testConversation()
+	for keep := 1; keep <= len(turns); keep++ {
+		exact := promptChars(joinMessages(system, turns[len(turns)-keep:]))
+		for _, tt := range []struct {
+			chars int
+			want  int
+		}{
+			{chars: exact, want: keep},         // 刚好等于预算时保留
+			{chars: exact - 1, want: keep - 1}, // 超出 1 个字符时再丢一轮
+		} {
+			want := tt.want
+			if want < 1 {
+				want = 1 // 最后一条消息总是保留
+			}
+			got := dropOldest(contextBudget{chars: tt.chars}, system, turns)
+			if !reflect.DeepEqual(got, joinMessages(system, turns[len(turns)-want:])) {
+				t.Errorf("chars=%d: 保留了 %d 条消息, want system + 最近 %d 轮", tt.chars, len(got), want)
+			}
+		}
+	}
+}
+
+func TestMiddleOut(t *testing.T) {
+	system, turns := testConversation()
+	ends := []Message{turns[0], turns[len(turns)-1]}
+	exact := promptChars(joinMessages(system, ends))
+
+	t.Run("刚好放下首尾时丢弃中间的轮次", func(t *testing.T) {
+		got := middleOut(contextBudget{chars: exact}, system, append([]Message(nil), turns...))
+		if !reflect.DeepEqual(got, joinMessages(system, ends)) {
+			t.Errorf("got %d 条消息, want system + 第一轮与最后一轮", len(got))
+		}
+	})
+
+	t.Run("超出 1 个字符时截去最长消息的中间部分", func(t *testing.T) {
+		input := append([]Message(nil), turns...)
+		got := middleOut(contextBudget{chars: exact - 1}, system, input)
+		if !reflect.DeepEqual(input, turns) {
+			t.Error("不应修改传入的消息")
+		}
+		if len(got) != 3 || !reflect.DeepEqual(got[0], system[0]) {
+			t.Fatalf("got %+v, want system + 首尾两条", got)
+		}
+		if !strings.Contains(got[2].Content, truncatedMarker) || !reflect.DeepEqual(got[1], turns[0]) {
+			t.Errorf("应截断最长的最后一条消息: %+v", got)
+		}
+		if promptChars(got) > exact-1 {
+			t.Errorf("截断后 %d 字符, 超出预算 %d", promptChars(got), exact-1)
+		}
+	})
+
+	// 在预算边界附近逐个取值, 只要还有消息可以截短, 处理后都应不超出预算
+	full := joinMessages(system, turns)
+	budgets := []func(n int) contextBudget{
+		func(n int) contextBudget { return contextBudget{chars: n} },
+		func(n int) contextBudget { return contextBudget{words: n / 5} },
+		func(n int) contextBudget {
+			return contextBudget{tokens: n / 4, tok: estimateTokenizer{name: EncodingCL100K}}
+		},
+	}
+	for i, newBudget := range budgets {
+		t.Run(fmt.Sprintf("预算类型 %d", i), func(t *testing.T) {
+			for n := promptChars(full) + 10; n >= 100; n -= 7 {
+				budget := newBudget(n)
+				got := middleOut(budget, system, append([]Message(nil), turns...))
+				if !reflect.DeepEqual(got[0], system[0]) {
+					t.Fatalf("n=%d: system 应保留", n)
+				}
+				if !budget.fits(got) && !collapsed(got) {
+					t.Fatalf("n=%d: 处理后用量 %.3f, 超出预算", n, budget.usage(got))
+				}
+			}
+		})
+	}
+}
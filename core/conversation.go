@@ -0,0 +1,126 @@
+package core
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	promptSeparator = "\n\n---\n\n"
+	historyLabel    = "[Conversation History]\n"
+	partialLabel    = "[Conversation History (partial, oldest trimmed)]\n"
+)
+
+// buildPrompt 将完整的 messages[] 拼接为发往 Sider 的单个 prompt.
+// Sider 的 completion 接口只接受一段文本, 因此 system / 历史 / 当前问题按固定格式拼接.
+//
+// 超长策略: system 与当前问题必须保留, 历史从最新往最旧逐条填充,
+// 超出字符或词数预算后丢弃更早的轮次, 并在标题中注明历史已截断.
+// 返回的 truncated 表示是否丢弃了历史消息.
+func buildPrompt(messages []Message, maxChars, maxWords int) (prompt string, truncated bool) {
+	var systemParts []string
+	var turns []Message
+	for _, m := range messages {
+		if m.Role == "system" || m.Role == "developer" {
+			if m.Content != "" {
+				systemParts = append(systemParts, m.Content)
+			}
+			continue
+		}
+		turns = append(turns, m)
+	}
+
+	if len(turns) == 0 && len(systemParts) == 0 {
+		return "", false
+	}
+	// 单条用户消息且无 system 时直接返回原文, 与旧版本行为一致
+	if len(turns) == 1 && len(systemParts) == 0 {
+		return turns[0].Content, false
+	}
+
+	systemPart := ""
+	if len(systemParts) > 0 {
+		systemPart = "[System]\n" + strings.Join(systemParts, "\n\n")
+	}
+	currentPart := ""
+	if len(turns) > 0 {
+		currentPart = "[Current Question]\n" + turns[len(turns)-1].Content
+		turns = turns[:len(turns)-1]
+	}
+
+	// 固定部分占用的预算
+	usedChars := utf8.RuneCountInString(systemPart) + utf8.RuneCountInString(currentPart) +
+		2*utf8.RuneCountInString(promptSeparator) + utf8.RuneCountInString(partialLabel)
+	usedWords := estimateWordCount(systemPart) + estimateWordCount(currentPart)
+
+	// 历史消息从最新到最旧逐条填充，超出字符或词数预算则停止
+	var selected []string
+	for i := len(turns) - 1; i >= 0; i-- {
+		line := fmt.Sprintf("%s: %s", roleLabel(turns[i].Role), turns[i].Content)
+		lineChars := utf8.RuneCountInString(line) + 2
+		lineWords := estimateWordCount(line)
+		if (maxChars > 0 && usedChars+lineChars > maxChars) || (maxWords > 0 && usedWords+lineWords > maxWords) {
+			truncated = true
+			break
+		}
+		selected = append([]string{line}, selected...)
+		usedChars += lineChars
+		usedWords += lineWords
+	}
+
+	var parts []string
+	if systemPart != "" {
+		parts = append(parts, systemPart)
+	}
+	if len(selected) > 0 {
+		label := historyLabel
+		if truncated {
+			label = partialLabel
+		}
+		parts = append(parts, label+strings.Join(selected, "\n\n"))
+	}
+	if currentPart != "" {
+		parts = append(parts, currentPart)
+	}
+
+	return strings.Join(parts, promptSeparator), truncated
+}
+
+func roleLabel(role string) string {
+	switch role {
+	case "assistant":
+		return "Assistant"
+	case "user", "":
+		return "User"
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// estimateWordCount 估算文本词数：中日韩字符各计 1 词，其余按空白分词.
+// 用于防止触发 Sider code:603 "Too many words" 限制.
+func estimateWordCount(text string) int {
+	count := 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case isCJK(r):
+			count++
+			inWord = false
+		case unicode.IsSpace(r):
+			inWord = false
+		default:
+			if !inWord {
+				count++
+				inWord = true
+			}
+		}
+	}
+	return count
+}
+
+func isCJK(r rune) bool {
+	return unicode.Is(unicode.Han, r) || unicode.Is(unicode.Hiragana, r) ||
+		unicode.Is(unicode.Katakana, r) || unicode.Is(unicode.Hangul, r)
+}
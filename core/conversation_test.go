@@ -0,0 +1,90 @@
+package core
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestBuildPrompt(t *testing.T) {
+	const (
+		system  = "[System]\nBe brief."
+		current = "[Current Question]\nand now?"
+	)
+	conversation := []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "first question"},
+		{Role: "assistant", Content: "first answer"},
+		{Role: "tool", Content: "[Tool Result] 42"},
+		{Role: "user", Content: "and now?"},
+	}
+	full := system + promptSeparator + historyLabel +
+		"User: first question\n\nAssistant: first answer\n\nTool: [Tool Result] 42" + promptSeparator + current
+
+	// 固定部分 (system, 当前问题, 分隔符与截断标题) 占用的预算, 之后逐条放入最新的历史
+	fixedChars := utf8.RuneCountInString(system+current+promptSeparator+promptSeparator) + utf8.RuneCountInString(partialLabel)
+	lineChars := func(line string) int { return utf8.RuneCountInString(line) + 2 }
+	fixedWords := estimateWordCount(system) + estimateWordCount(current)
+
+	tests := []struct {
+		name          string
+		messages      []Message
+		maxChars      int
+		maxWords      int
+		want          string
+		wantTruncated bool
+	}{
+		{name: "空对话", want: ""},
+		{name: "单条用户消息原样返回", messages: []Message{{Role: "user", Content: "hi"}}, want: "hi"},
+		{name: "system 与当前问题", messages: []Message{{Role: "developer", Content: "Be brief."}, {Role: "user", Content: "and now?"}},
+			want: system + promptSeparator + current},
+		{name: "多条 system 合并, 空的跳过",
+			messages: []Message{{Role: "system", Content: "A"}, {Role: "system"}, {Role: "developer", Content: "B"}, {Role: "user", Content: "and now?"}},
+			want:     "[System]\nA\n\nB" + promptSeparator + current},
+		{name: "不限制时保留全部历史", messages: conversation, want: full},
+		{name: "刚好放下全部历史", messages: conversation,
+			maxChars: fixedChars + lineChars("User: first question") + lineChars("Assistant: first answer") + lineChars("Tool: [Tool Result] 42"),
+			want:     full},
+		{name: "字符数超出时丢弃最早的轮次", messages: conversation,
+			maxChars:      fixedChars + lineChars("Assistant: first answer") + lineChars("Tool: [Tool Result] 42"),
+			want:          system + promptSeparator + partialLabel + "Assistant: first answer\n\nTool: [Tool Result] 42" + promptSeparator + current,
+			wantTruncated: true},
+		{name: "词数超出时丢弃最早的轮次", messages: conversation,
+			maxWords: fixedWords + estimateWordCount("Tool: [Tool Result] 42"),
+			want:     system + promptSeparator + partialLabel + "Tool: [Tool Result] 42" + promptSeparator + current, wantTruncated: true},
+		{name: "放不下任何历史时只保留 system 与当前问题", messages: conversation, maxChars: fixedChars,
+			want: system + promptSeparator + current, wantTruncated: true},
+		{name: "较早的轮次放得下也不跳过较新的轮次", messages: conversation,
+			maxChars: fixedChars + lineChars("User: first question"),
+			want:     system + promptSeparator + current, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, truncated := buildPrompt(tt.messages, tt.maxChars, tt.maxWords)
+			if got != tt.want {
+				t.Errorf("prompt = %q\nwant %q", got, tt.want)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestEstimateWordCount(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{text: "", want: 0},
+		{text: "hello world", want: 2},
+		{text: "  spaced\n\tout  ", want: 2},
+		{text: "你好世界", want: 4},
+		{text: "hello你好world", want: 4},
+		{text: "こんにちは 안녕", want: 7},
+	}
+	for _, tt := range tests {
+		if got := estimateWordCount(tt.text); got != tt.want {
+			t.Errorf("estimateWordCount(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// apiError 是返回给客户端之前的内部错误, 由各协议处理器转换为自己的错误格式
+type apiError struct {
+	Status     int
+	Type       string
+	Code       string // OpenAI 错误结构中的 code, 可为空
+	Message    string
+	RetryAfter int         // 秒, 大于 0 时输出 Retry-After 头
+	Header     http.Header // 需要额外输出的响应头, 如限流时的 x-ratelimit-*
+}
+
+func (e *apiError) Error() string {
+	return e.Message
+}
+
+func newAPIError(status int, errType, message string) *apiError {
+	return &apiError{Status: status, Type: errType, Message: message}
+}
+
+// Sider 已知的错误码
+const (
+	siderCodeTooManyWords  = 603
+	siderCodeUnauthorized  = 1001
+	siderCodeRateLimited   = 1101
+	siderCodeQuotaExceeded = 1135
+)
+
+// siderError 将 Sider 的错误码转换为对客户端的错误
+func siderError(code int, msg string) *apiError {
+	message := fmt.Sprintf("Sider 上游错误 (code %d)", code)
+	if msg != "" {
+		message += ": " + msg
+	}
+
+	switch code {
+	case siderCodeTooManyWords:
+		return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "context_length_exceeded", Message: message}
+	case siderCodeUnauthorized:
+		return &apiError{Status: http.StatusUnauthorized, Type: "authentication_error", Code: "upstream_unauthorized", Message: message}
+	case siderCodeRateLimited, siderCodeQuotaExceeded:
+		return &apiError{Status: http.StatusTooManyRequests, Type: "rate_limit_error", Code: "rate_limit_exceeded", Message: message, RetryAfter: retryAfterSeconds(msg)}
+	}
+	return &apiError{Status: http.StatusBadGateway, Type: "upstream_error", Code: strconv.Itoa(code), Message: message}
+}
+
+var retryAfterPattern = regexp.MustCompile(`(?i)after\s+(\d+)\s*(second|sec|minute|min|hour|h)`)
+
+// retryAfterSeconds 从 "try again after 69 minutes" 之类的提示中解析等待时间, 解析不到时默认 60 秒
+func retryAfterSeconds(msg string) int {
+	m := retryAfterPattern.FindStringSubmatch(msg)
+	if m == nil {
+		return 60
+	}
+	n, _ := strconv.Atoi(m[1])
+	unit := strings.ToLower(m[2])
+	switch {
+	case strings.HasPrefix(unit, "h"):
+		return n * 3600
+	case strings.HasPrefix(unit, "min"):
+		return n * 60
+	}
+	return n
+}
+
+// upstreamHTTPError 处理上游非 200 响应: 优先解析响应体中的错误码, 否则按 HTTP 状态码转换
+func upstreamHTTPError(resp *http.Response) *apiError {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	fmt.Printf("Sider返回非200响应: %d %s\n", resp.StatusCode, strings.TrimSpace(string(body)))
+
+	data := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(string(body)), "data:"))
+	if ev, err := decodeSiderEvent([]byte(data)); err == nil && ev.Type == EventError {
+		return siderError(ev.Code, ev.Msg)
+	}
+
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return siderError(siderCodeUnauthorized, resp.Status)
+	case http.StatusTooManyRequests:
+		e := siderError(siderCodeRateLimited, resp.Status)
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			e.RetryAfter = seconds
+		}
+		return e
+	}
+	return &apiError{Status: http.StatusBadGateway, Type: "upstream_error", Code: "upstream_error",
+		Message: fmt.Sprintf("Sider 上游返回 %s", resp.Status)}
+}
+
+// toAPIError 将读取响应时的错误统一为 *apiError: 上游错误码原样保留, 其余视为网关错误
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return &apiError{Status: http.StatusBadGateway, Type: "upstream_error", Code: "upstream_error", Message: "读取响应失败"}
+}
+
+// setErrorHeaders 输出错误附带的响应头, 限流错误时包括 Retry-After
+func setErrorHeaders(w http.ResponseWriter, apiErr *apiError) {
+	for k, v := range apiErr.Header {
+		w.Header()[k] = v
+	}
+	if apiErr.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.RetryAfter))
+	}
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRetryAfterSeconds(t *testing.T) {
+	tests := []struct {
+		msg  string
+		want int
+	}{
+		{msg: "You have reached the limit, try again after 69 minutes", want: 69 * 60},
+		{msg: "try again after 30 seconds", want: 30},
+		{msg: "Try Again After 2 Hours", want: 2 * 3600},
+		{msg: "retry after 5min", want: 5 * 60},
+		{msg: "retry after 10 sec", want: 10},
+		{msg: "after 3h", want: 3 * 3600},
+		{msg: "too many requests", want: 60},
+		{msg: "", want: 60},
+	}
+	for _, tt := range tests {
+		if got := retryAfterSeconds(tt.msg); got != tt.want {
+			t.Errorf("retryAfterSeconds(%q) = %d, want %d", tt.msg, got, tt.want)
+		}
+	}
+}
+
+func TestSiderError(t *testing.T) {
+	tests := []struct {
+		code       int
+		msg        string
+		status     int
+		errType    string
+		errCode    string
+		retryAfter int
+	}{
+		{code: siderCodeTooManyWords, msg: "Too many words", status: http.StatusBadRequest, errType: "invalid_request_error", errCode: "context_length_exceeded"},
+		{code: siderCodeUnauthorized, msg: "unauthorized", status: http.StatusUnauthorized, errType: "authentication_error", errCode: "upstream_unauthorized"},
+		{code: siderCodeRateLimited, msg: "try again after 2 minutes", status: http.StatusTooManyRequests, errType: "rate_limit_error", errCode: "rate_limit_exceeded", retryAfter: 120},
+		{code: siderCodeQuotaExceeded, msg: "quota exceeded", status: http.StatusTooManyRequests, errType: "rate_limit_error", errCode: "rate_limit_exceeded", retryAfter: 60},
+		{code: 500, msg: "internal", status: http.StatusBadGateway, errType: "upstream_error", errCode: "500"},
+	}
+	for _, tt := range tests {
+		got := siderError(tt.code, tt.msg)
+		if got.Status != tt.status || got.Type != tt.errType || got.Code != tt.errCode || got.RetryAfter != tt.retryAfter {
+			t.Errorf("siderError(%d) = %d %s %s retry=%d, want %d %s %s retry=%d", tt.code,
+				got.Status, got.Type, got.Code, got.RetryAfter, tt.status, tt.errType, tt.errCode, tt.retryAfter)
+		}
+		if !strings.Contains(got.Message, tt.msg) {
+			t.Errorf("siderError(%d) 的消息 %q 应包含上游提示 %q", tt.code, got.Message, tt.msg)
+		}
+	}
+	if got := siderError(500, ""); got.Message != "Sider 上游错误 (code 500)" {
+		t.Errorf("没有上游提示时消息 = %q", got.Message)
+	}
+}
+
+// 上游非 200 响应: 响应体中的错误码优先, 否则按 HTTP 状态码转换
+func TestUpstreamHTTPError(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		header     http.Header
+		body       string
+		errCode    string
+		retryAfter int
+	}{
+		{name: "响应体中的错误事件", status: http.StatusBadRequest, body: `data: {"code":603,"msg":"Too many words"}`, errCode: "context_length_exceeded"},
+		{name: "响应体中的限流事件", status: http.StatusOK, body: `{"code":1135,"msg":"try again after 1 hour"}`, errCode: "rate_limit_exceeded", retryAfter: 3600},
+		{name: "401", status: http.StatusUnauthorized, body: "Unauthorized", errCode: "upstream_unauthorized"},
+		{name: "403", status: http.StatusForbidden, errCode: "upstream_unauthorized"},
+		{name: "429 带 Retry-After", status: http.StatusTooManyRequests, header: http.Header{"Retry-After": {"17"}}, errCode: "rate_limit_exceeded", retryAfter: 17},
+		{name: "429 不带 Retry-After", status: http.StatusTooManyRequests, errCode: "rate_limit_exceeded", retryAfter: 60},
+		{name: "其他状态码", status: http.StatusInternalServerError, body: "<html>oops</html>", errCode: "upstream_error"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Status:     http.StatusText(tt.status),
+				Header:     tt.header,
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			got := upstreamHTTPError(resp)
+			if got.Code != tt.errCode || got.RetryAfter != tt.retryAfter {
+				t.Errorf("got %s retry=%d, want %s retry=%d", got.Code, got.RetryAfter, tt.errCode, tt.retryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Sider SSE 事件类型
+const (
+	EventMessageStart = "message_start"
+	EventText         = "text"
+	EventReasoning    = "reasoning_content"
+	EventToolCall     = "tool_call"
+	EventFile         = "file"
+	EventCreditInfo   = "credit_info"
+	EventPulse        = "pulse"
+	EventError        = "error" // 非上游类型: 顶层 code 非 0 时由解码器标记
+)
+
+// SiderEvent 是解码后的一条上游事件. 各字段只在对应类型下有值:
+// text / reasoning_content 使用 Text, 其余类型使用同名字段.
+type SiderEvent struct {
+	Type         string
+	Text         string
+	ChatModel    string
+	MessageStart *SiderMessageStart
+	ToolCall     *SiderToolCall
+	File         *SiderFile
+	CreditInfo   json.RawMessage
+
+	Code int // 上游错误码, 仅 error 事件
+	Msg  string
+}
+
+// decodeSiderEvent 解析一行 SSE data 的 JSON 内容
+func decodeSiderEvent(data []byte) (SiderEvent, error) {
+	var raw SiderResponse
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return SiderEvent{}, err
+	}
+
+	ev := SiderEvent{Code: raw.Code, Msg: raw.Msg}
+	if raw.Code != 0 {
+		ev.Type = EventError
+		return ev, nil
+	}
+	if raw.Data == nil {
+		return ev, fmt.Errorf("事件缺少 data 字段")
+	}
+
+	d := raw.Data
+	ev.Type = d.Type
+	ev.ChatModel = d.ChatModel
+	switch d.Type {
+	case EventMessageStart:
+		ev.MessageStart = d.MessageStart
+	case EventText:
+		ev.Text = d.Text
+	case EventReasoning:
+		ev.Text = reasoningText(d.ReasoningContent)
+	case EventToolCall:
+		ev.ToolCall = d.ToolCall
+	case EventFile:
+		ev.File = d.File
+	case EventCreditInfo:
+		ev.CreditInfo = d.CreditInfo
+	}
+	return ev, nil
+}
+
+// reasoningText 兼容 {"text":"..."} 与纯字符串两种 reasoning_content 格式
+func reasoningText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var obj struct {
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		return obj.Text
+	}
+	var text string
+	json.Unmarshal(raw, &text)
+	return text
+}
+
+// Content 返回该事件应写入回复正文的内容: text 事件的文本, 或图片文件的 Markdown 链接
+func (ev SiderEvent) Content() string {
+	switch ev.Type {
+	case EventText:
+		return ev.Text
+	case EventFile:
+		if ev.File != nil && ev.File.Type == "image" && ev.File.URL != "" {
+			return fmt.Sprintf("\n\n![图片](%s)\n", ev.File.URL)
+		}
+	}
+	return ""
+}
+
+// Reasoning 返回 reasoning_content 事件的思考文本
+func (ev SiderEvent) Reasoning() string {
+	if ev.Type == EventReasoning {
+		return ev.Text
+	}
+	return ""
+}
@@ -0,0 +1,336 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Gemini generateContent 请求结构
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiPart struct {
+	Text       string      `json:"text"`
+	Thought    bool        `json:"thought,omitempty"` // 思考过程
+	InlineData *GeminiBlob `json:"inlineData,omitempty"`
+	FileData   *GeminiFile `json:"fileData,omitempty"`
+}
+
+// inlineData: base64 编码的图片
+type GeminiBlob struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+// fileData: 以地址引用的图片
+type GeminiFile struct {
+	MimeType string `json:"mimeType"`
+	FileURI  string `json:"fileUri"`
+}
+
+type GeminiGenerationConfig struct {
+	MaxOutputTokens int                   `json:"maxOutputTokens"`
+	Temperature     *float64              `json:"temperature"`
+	TopP            *float64              `json:"topP"`
+	StopSequences   []string              `json:"stopSequences"`
+	ThinkingConfig  *GeminiThinkingConfig `json:"thinkingConfig"`
+}
+
+type GeminiThinkingConfig struct {
+	ThinkingBudget  *int `json:"thinkingBudget"` // 0 表示关闭思考
+	IncludeThoughts bool `json:"includeThoughts"`
+}
+
+// Gemini 响应结构 (非流式与流式分块相同)
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount     int `json:"promptTokenCount"`
+	CandidatesTokenCount int `json:"candidatesTokenCount"`
+	TotalTokenCount      int `json:"totalTokenCount"`
+}
+
+// Gemini 错误响应结构
+type GeminiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Status  string `json:"status"`
+	} `json:"error"`
+}
+
+func geminiText(parts []GeminiPart) string {
+	texts := make([]string, 0, len(parts))
+	for _, p := range parts {
+		if p.Text != "" && !p.Thought {
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// geminiImages 返回 parts 中图片的地址
+func geminiImages(parts []GeminiPart) []string {
+	var images []string
+	for _, p := range parts {
+		switch {
+		case p.InlineData != nil && strings.HasPrefix(p.InlineData.MimeType, "image/"):
+			images = append(images, dataURL(p.InlineData.MimeType, p.InlineData.Data))
+		case p.FileData != nil && p.FileData.FileURI != "":
+			images = append(images, p.FileData.FileURI)
+		}
+	}
+	return images
+}
+
+// toUserRequest 将 Gemini 请求转换为内部统一的 UserRequest
+func (req *GeminiRequest) toUserRequest(model string, stream bool) *UserRequest {
+	userReq := &UserRequest{Model: model, Stream: stream}
+	if req.GenerationConfig != nil {
+		userReq.MaxTokens = req.GenerationConfig.MaxOutputTokens
+		if tc := req.GenerationConfig.ThinkingConfig; tc != nil && tc.ThinkingBudget != nil {
+			userReq.ReasoningEffort = "medium"
+			if *tc.ThinkingBudget == 0 {
+				userReq.ReasoningEffort = "none"
+			}
+		}
+	}
+	if req.SystemInstruction != nil {
+		if system := geminiText(req.SystemInstruction.Parts); system != "" {
+			userReq.Messages = append(userReq.Messages, Message{Role: "system", Content: system})
+		}
+	}
+	for _, c := range req.Contents {
+		role := "user"
+		if c.Role == "model" {
+			role = "assistant"
+		}
+		userReq.Messages = append(userReq.Messages, Message{Role: role, Content: geminiText(c.Parts), Images: geminiImages(c.Parts)})
+	}
+	return userReq
+}
+
+func newGeminiError(status int, message string) GeminiError {
+	var resp GeminiError
+	resp.Error.Code = status
+	resp.Error.Message = message
+	resp.Error.Status = geminiErrorStatus(status)
+	return resp
+}
+
+func writeGeminiError(w http.ResponseWriter, status int, message string) {
+	resp := newGeminiError(status, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// geminiErrorStatus 将 HTTP 状态码映射为 Google API 的错误状态
+func geminiErrorStatus(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	}
+	return "INTERNAL"
+}
+
+// includeThoughts 对应 thinkingConfig.includeThoughts, 只有客户端要求时才返回思考过程
+func (req *GeminiRequest) includeThoughts() bool {
+	return req.GenerationConfig != nil && req.GenerationConfig.ThinkingConfig != nil &&
+		req.GenerationConfig.ThinkingConfig.IncludeThoughts
+}
+
+func geminiChunk(text, finishReason string) GeminiResponse {
+	return geminiResponse([]GeminiPart{{Text: text}}, finishReason)
+}
+
+func geminiResponse(parts []GeminiPart, finishReason string) GeminiResponse {
+	return GeminiResponse{
+		Candidates: []GeminiCandidate{{
+			Content:      GeminiContent{Role: "model", Parts: parts},
+			FinishReason: finishReason,
+			Index:        0,
+		}},
+	}
+}
+
+func geminiFinishReason(finishReason string) string {
+	if finishReason == "length" {
+		return "MAX_TOKENS"
+	}
+	return "STOP"
+}
+
+// geminiHandler 处理 POST /v1beta/models/{model}:generateContent 与 :streamGenerateContent
+func (s *Server) geminiHandler(w http.ResponseWriter, r *http.Request) {
+	// 匹配 {model}:{action}
+	target := strings.TrimPrefix(r.URL.Path, s.cfg.RoutePrefix+"/v1beta/models/")
+	i := strings.LastIndex(target, ":")
+	if i <= 0 || r.Method != "POST" {
+		writeGeminiError(w, http.StatusNotFound, "unknown route: "+r.URL.Path)
+		return
+	}
+	model, action := target[:i], target[i+1:]
+	var stream bool
+	switch action {
+	case "generateContent":
+	case "streamGenerateContent":
+		stream = true
+	default:
+		writeGeminiError(w, http.StatusNotFound, "unsupported action: "+action)
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeGeminiError(w, http.StatusBadRequest, "读取请求失败")
+		return
+	}
+	defer r.Body.Close()
+
+	var req GeminiRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Printf("解析请求体失败: %v\n", err)
+		writeGeminiError(w, http.StatusBadRequest, "解析请求失败")
+		return
+	}
+	if len(req.Contents) == 0 {
+		writeGeminiError(w, http.StatusBadRequest, "contents is not specified")
+		return
+	}
+
+	c, apiErr := s.startCompletion(r, req.toUserRequest(model, stream))
+	if apiErr != nil {
+		setErrorHeaders(w, apiErr)
+		writeGeminiError(w, apiErr.Status, apiErr.Message)
+		return
+	}
+	defer c.finish()
+
+	c.setHeaders(w)
+	usage := func() *GeminiUsageMetadata {
+		prompt, completion := c.PromptTokens(), c.CompletionTokens()
+		return &GeminiUsageMetadata{
+			PromptTokenCount:     prompt,
+			CandidatesTokenCount: completion,
+			TotalTokenCount:      prompt + completion,
+		}
+	}
+
+	if !stream || !c.Stream {
+		if err := c.each(func(SiderEvent) bool { return true }); err != nil {
+			fmt.Printf("读取响应失败: %v\n", err)
+			apiErr := toAPIError(err)
+			setErrorHeaders(w, apiErr)
+			writeGeminiError(w, apiErr.Status, apiErr.Message)
+			return
+		}
+		parts := []GeminiPart{{Text: c.Text()}}
+		if reasoning := c.Reasoning(); reasoning != "" && req.includeThoughts() {
+			parts = append([]GeminiPart{{Text: reasoning, Thought: true}}, parts...)
+		}
+		resp := geminiResponse(parts, geminiFinishReason(c.FinishReason()))
+		resp.UsageMetadata = usage()
+		resp.ModelVersion = c.Model
+		w.Header().Set("X-Conversation-ID", c.ConversationID())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(resp)
+		return
+	}
+
+	// 流式: alt=sse 时输出 SSE, 否则按 Google REST 约定输出逐步写入的 JSON 数组
+	sse := r.URL.Query().Get("alt") == "sse"
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	first := true
+	writeChunk := func(chunk interface{}) bool {
+		payload, err := json.Marshal(chunk)
+		if err != nil {
+			fmt.Printf("转换Gemini格式失败: %v\n", err)
+			return true
+		}
+		switch {
+		case sse:
+			_, err = fmt.Fprintf(w, "data: %s\n\n", payload)
+		case first:
+			_, err = fmt.Fprintf(w, "[%s", payload)
+		default:
+			_, err = fmt.Fprintf(w, ",\r\n%s", payload)
+		}
+		first = false
+		if err != nil {
+			fmt.Printf("写入响应失败: %v\n", err)
+			return false
+		}
+		flush(w)
+		return true
+	}
+
+	err = c.each(func(ev SiderEvent) bool {
+		if ev.Type == EventPulse && sse {
+			return writeKeepAlive(w)
+		}
+		if reasoning := ev.Reasoning(); reasoning != "" && req.includeThoughts() {
+			return writeChunk(geminiResponse([]GeminiPart{{Text: reasoning, Thought: true}}, ""))
+		}
+		text := ev.Content()
+		if text == "" {
+			return true
+		}
+		return writeChunk(geminiChunk(text, ""))
+	})
+	if err != nil {
+		// 流内错误: 以 Google 错误对象结束输出
+		fmt.Printf("读取响应失败: %v\n", err)
+		apiErr := toAPIError(err)
+		writeChunk(newGeminiError(apiErr.Status, apiErr.Message))
+	} else {
+		last := geminiChunk("", geminiFinishReason(c.FinishReason()))
+		last.UsageMetadata = usage()
+		last.ModelVersion = c.Model
+		writeChunk(last)
+	}
+	if !sse {
+		fmt.Fprint(w, "]")
+		flush(w)
+	}
+}
@@ -0,0 +1,224 @@
+package core
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 单次请求最多生成的图片数
+const maxImagesPerRequest = 4
+
+// 下载上游图片的大小上限 (b64_json 时使用)
+const maxImageBytes = 20 << 20
+
+// OpenAI 图片生成请求
+type ImageRequest struct {
+	Prompt         string `json:"prompt"`
+	Model          string `json:"model"`
+	N              int    `json:"n"`
+	Size           string `json:"size"`
+	Quality        string `json:"quality"`
+	Style          string `json:"style"`
+	ResponseFormat string `json:"response_format"` // url (默认) / b64_json
+}
+
+// OpenAI 图片生成响应
+type ImageResponse struct {
+	Created int64       `json:"created"`
+	Data    []ImageData `json:"data"`
+}
+
+type ImageData struct {
+	URL     string `json:"url,omitempty"`
+	B64JSON string `json:"b64_json,omitempty"`
+}
+
+// imagesHandler 处理 /v1/images/generations: 以 text_to_image 工具调用上游图片模型,
+// 收集流中的 file 事件, 不足 n 张时再次请求. 限流、配额与请求统计按一次客户端请求计算
+func (s *Server) imagesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fmt.Printf("读取请求体失败: %v\n", err)
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "读取请求失败", "")
+		return
+	}
+	defer r.Body.Close()
+
+	var req ImageRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Printf("解析请求体失败: %v\n", err)
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "解析请求失败", "")
+		return
+	}
+	if apiErr := s.validateImageRequest(&req); apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+
+	// 图片模型较慢, 使用单独的超时时间
+	ctx, cancel := context.WithTimeout(r.Context(), s.cfg.ImageTimeout)
+	defer cancel()
+	r = r.WithContext(ctx)
+
+	var images []SiderFile
+	for len(images) < req.N {
+		files, apiErr := s.generateImages(w, r, &req, len(images) == 0)
+		if apiErr != nil {
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				apiErr = &apiError{Status: http.StatusGatewayTimeout, Type: "upstream_error", Code: "timeout",
+					Message: fmt.Sprintf("图片生成超时 (%s)", s.cfg.ImageTimeout)}
+			}
+			writeAPIError(w, apiErr)
+			return
+		}
+		images = append(images, files...)
+	}
+	if len(images) > req.N {
+		images = images[:req.N]
+	}
+
+	resp := ImageResponse{Created: time.Now().Unix(), Data: make([]ImageData, len(images))}
+	for i, img := range images {
+		if req.ResponseFormat != "b64_json" {
+			resp.Data[i].URL = img.URL
+			continue
+		}
+		data, err := s.fetchImage(ctx, img.URL)
+		if err != nil {
+			fmt.Printf("下载图片失败: %v\n", err)
+			writeOpenAIError(w, http.StatusBadGateway, "upstream_error", "下载上游图片失败", "upstream_error")
+			return
+		}
+		resp.Data[i].B64JSON = base64.StdEncoding.EncodeToString(data)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// validateImageRequest 检查参数并填充缺省值
+func (s *Server) validateImageRequest(req *ImageRequest) *apiError {
+	if strings.TrimSpace(req.Prompt) == "" {
+		return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "invalid_prompt", Message: "prompt 不能为空"}
+	}
+	if req.N == 0 {
+		req.N = 1
+	}
+	if req.N < 1 || req.N > maxImagesPerRequest {
+		return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "invalid_value",
+			Message: fmt.Sprintf("n 的取值范围为 1-%d", maxImagesPerRequest)}
+	}
+	switch req.ResponseFormat {
+	case "":
+		req.ResponseFormat = "url"
+	case "url", "b64_json":
+	default:
+		return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "invalid_value",
+			Message: "response_format 只支持 url 或 b64_json"}
+	}
+	if req.Model == "" {
+		req.Model = s.cfg.ImageModel
+	}
+	info, ok := s.models.Resolve(req.Model)
+	if !ok {
+		return modelNotFound(req.Model)
+	}
+	if !info.Has(CapabilityImage) {
+		return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "model_not_supported",
+			Message: fmt.Sprintf("模型 %s 不支持图片生成", info.ID)}
+	}
+	return nil
+}
+
+// imagePrompt 将尺寸、质量与风格要求附加到描述后面, 由上游的画图工具理解
+func imagePrompt(req *ImageRequest) string {
+	var hints []string
+	if req.Size != "" {
+		hints = append(hints, "尺寸: "+req.Size)
+	}
+	if req.Quality != "" {
+		hints = append(hints, "质量: "+req.Quality)
+	}
+	if req.Style != "" {
+		hints = append(hints, "风格: "+req.Style)
+	}
+	prompt := "请生成图片: " + req.Prompt
+	if len(hints) > 0 {
+		prompt += "\n(" + strings.Join(hints, ", ") + ")"
+	}
+	return prompt
+}
+
+// generateImages 以流式发送一次画图请求, 返回上游生成的图片. 上游只回复了文字时视为失败.
+// 只有第一次请求计入限流与配额, 为凑足 n 张发出的后续请求不再重复计入
+func (s *Server) generateImages(w http.ResponseWriter, r *http.Request, req *ImageRequest, first bool) ([]SiderFile, *apiError) {
+	userReq := &UserRequest{
+		Model:      req.Model,
+		Messages:   []Message{{Role: "user", Content: imagePrompt(req)}},
+		Stream:     true,
+		siderTools: []string{"text_to_image"},
+		internal:   true, // 每次画图都是独立的单轮对话, 不做上下文管理, 也不保存无法接续的会话
+		charged:    !first,
+	}
+	c, apiErr := s.startCompletion(r, userReq)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	defer c.finish()
+	if first {
+		c.setHeaders(w)
+	}
+
+	if err := c.each(func(SiderEvent) bool { return true }); err != nil {
+		fmt.Printf("读取响应失败: %v\n", err)
+		return nil, toAPIError(err)
+	}
+
+	var images []SiderFile
+	for _, f := range c.Files() {
+		if f.Type == "image" && f.URL != "" {
+			images = append(images, f)
+		}
+	}
+	if len(images) == 0 {
+		message := "上游未返回图片"
+		if text := strings.TrimSpace(c.Text()); text != "" {
+			message += ": " + text
+		}
+		c.upstreamErr = &apiError{Status: http.StatusBadGateway, Type: "upstream_error", Code: "no_image", Message: message}
+		return nil, c.upstreamErr
+	}
+	fmt.Printf("生成了 %d 张图片\n", len(images))
+	return images, nil
+}
+
+// fetchImage 下载上游生成的图片
+func (s *Server) fetchImage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("图片地址返回 %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxImageBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxImageBytes {
+		return nil, fmt.Errorf("图片超过 %d 字节", maxImageBytes)
+	}
+	return data, nil
+}
@@ -0,0 +1,252 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// jsonSchema 是结构化输出使用的 JSON Schema 校验器, 支持常用的关键字:
+// type / enum / const / properties / required / additionalProperties / items /
+// min/maxItems / min/maxLength / pattern / minimum / maximum / anyOf / oneOf / allOf
+// 以及指向 #/$defs 与 #/definitions 的 $ref. 不认识的关键字忽略.
+type jsonSchema struct {
+	root map[string]interface{}
+}
+
+func newJSONSchema(raw json.RawMessage) (*jsonSchema, error) {
+	var root map[string]interface{}
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return nil, fmt.Errorf("schema 必须是 JSON 对象: %v", err)
+	}
+	return &jsonSchema{root: root}, nil
+}
+
+// Validate 校验由 json.Decoder.UseNumber 解码得到的值
+func (s *jsonSchema) Validate(v interface{}) error {
+	return s.validate(s.root, v, "$", 0)
+}
+
+func (s *jsonSchema) validate(schema map[string]interface{}, v interface{}, path string, depth int) error {
+	if depth > 64 {
+		return fmt.Errorf("%s: schema 嵌套过深", path)
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		target, err := s.resolve(ref)
+		if err != nil {
+			return err
+		}
+		return s.validate(target, v, path, depth+1)
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, v) {
+		return fmt.Errorf("%s: 应为 %v 类型", path, t)
+	}
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, e := range enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: 取值不在 enum 中", path)
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, v) {
+		return fmt.Errorf("%s: 应为 %v", path, c)
+	}
+
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, ok := schema[key].([]interface{})
+		if !ok {
+			continue
+		}
+		matched := 0
+		var firstErr error
+		for _, sub := range subs {
+			subSchema, _ := sub.(map[string]interface{})
+			if err := s.validate(subSchema, v, path, depth+1); err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				continue
+			}
+			matched++
+		}
+		switch {
+		case key == "allOf" && matched != len(subs):
+			return firstErr
+		case key == "anyOf" && matched == 0:
+			return fmt.Errorf("%s: 不符合 anyOf 中的任何一项 (%v)", path, firstErr)
+		case key == "oneOf" && matched != 1:
+			return fmt.Errorf("%s: 应恰好符合 oneOf 中的一项, 实际符合 %d 项", path, matched)
+		}
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return s.validateObject(schema, val, path, depth)
+	case []interface{}:
+		if n, ok := schemaNumber(schema, "minItems"); ok && float64(len(val)) < n {
+			return fmt.Errorf("%s: 至少需要 %v 项", path, n)
+		}
+		if n, ok := schemaNumber(schema, "maxItems"); ok && float64(len(val)) > n {
+			return fmt.Errorf("%s: 最多 %v 项", path, n)
+		}
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range val {
+				if err := s.validate(items, item, fmt.Sprintf("%s[%d]", path, i), depth+1); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		length := float64(utf8.RuneCountInString(val))
+		if n, ok := schemaNumber(schema, "minLength"); ok && length < n {
+			return fmt.Errorf("%s: 长度至少为 %v", path, n)
+		}
+		if n, ok := schemaNumber(schema, "maxLength"); ok && length > n {
+			return fmt.Errorf("%s: 长度最多为 %v", path, n)
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			re, err := regexp.Compile(pattern)
+			if err == nil && !re.MatchString(val) {
+				return fmt.Errorf("%s: 不匹配 %s", path, pattern)
+			}
+		}
+	case json.Number:
+		f, _ := val.Float64()
+		if n, ok := schemaNumber(schema, "minimum"); ok && f < n {
+			return fmt.Errorf("%s: 应不小于 %v", path, n)
+		}
+		if n, ok := schemaNumber(schema, "maximum"); ok && f > n {
+			return fmt.Errorf("%s: 应不大于 %v", path, n)
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateObject(schema map[string]interface{}, obj map[string]interface{}, path string, depth int) error {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, exists := obj[name]; !exists {
+				return fmt.Errorf("%s: 缺少必需字段 %q", path, name)
+			}
+		}
+	}
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, value := range obj {
+		child := path + "." + name
+		if prop, ok := properties[name].(map[string]interface{}); ok {
+			if err := s.validate(prop, value, child, depth+1); err != nil {
+				return err
+			}
+			continue
+		}
+		switch extra := schema["additionalProperties"].(type) {
+		case bool:
+			if !extra {
+				return fmt.Errorf("%s: 不允许的字段 %q", path, name)
+			}
+		case map[string]interface{}:
+			if err := s.validate(extra, value, child, depth+1); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve 解析文档内的 $ref, 如 #/$defs/item
+func (s *jsonSchema) resolve(ref string) (map[string]interface{}, error) {
+	if ref == "#" {
+		return s.root, nil
+	}
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("不支持的 $ref: %s", ref)
+	}
+	var node interface{} = s.root
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.ReplaceAll(strings.ReplaceAll(part, "~1", "/"), "~0", "~")
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("无法解析 $ref: %s", ref)
+		}
+		node = m[part]
+	}
+	target, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("无法解析 $ref: %s", ref)
+	}
+	return target, nil
+}
+
+// matchesType 判断值是否符合 type (字符串或字符串数组)
+func matchesType(t interface{}, v interface{}) bool {
+	if list, ok := t.([]interface{}); ok {
+		for _, item := range list {
+			if matchesType(item, v) {
+				return true
+			}
+		}
+		return false
+	}
+	name, _ := t.(string)
+	switch val := v.(type) {
+	case nil:
+		return name == "null"
+	case bool:
+		return name == "boolean"
+	case string:
+		return name == "string"
+	case json.Number:
+		if name == "number" {
+			return true
+		}
+		f, err := val.Float64()
+		return name == "integer" && err == nil && f == math.Trunc(f)
+	case []interface{}:
+		return name == "array"
+	case map[string]interface{}:
+		return name == "object"
+	}
+	return false
+}
+
+// jsonEqual 比较 schema 中的值 (数字为 float64) 与待校验的值 (数字为 json.Number)
+func jsonEqual(a, b interface{}) bool {
+	return reflect.DeepEqual(normalizeJSONNumbers(a), normalizeJSONNumbers(b))
+}
+
+func normalizeJSONNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = normalizeJSONNumbers(item)
+		}
+		return out
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(val))
+		for k, item := range val {
+			out[k] = normalizeJSONNumbers(item)
+		}
+		return out
+	}
+	return v
+}
+
+func schemaNumber(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(float64)
+	return n, ok
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONSchemaValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		value   string
+		wantErr string // 为空表示应通过校验
+	}{
+		{name: "类型正确", schema: `{"type": "string"}`, value: `"a"`},
+		{name: "类型错误", schema: `{"type": "string"}`, value: `1`, wantErr: "应为 string 类型"},
+		{name: "integer 接受整数值的浮点", schema: `{"type": "integer"}`, value: `3.0`},
+		{name: "integer 拒绝小数", schema: `{"type": "integer"}`, value: `3.5`, wantErr: "integer"},
+		{name: "类型列表", schema: `{"type": ["string", "null"]}`, value: `null`},
+		{name: "enum", schema: `{"enum": ["a", 1]}`, value: `1`},
+		{name: "enum 不匹配", schema: `{"enum": ["a", 1]}`, value: `"b"`, wantErr: "enum"},
+		{name: "const", schema: `{"const": {"x": [1]}}`, value: `{"x": [1.0]}`},
+		{name: "缺少必需字段", schema: `{"type": "object", "required": ["a"]}`, value: `{}`, wantErr: `缺少必需字段 "a"`},
+		{name: "属性校验带路径", schema: `{"properties": {"a": {"properties": {"b": {"type": "number"}}}}}`, value: `{"a": {"b": "x"}}`, wantErr: "$.a.b"},
+		{name: "禁止额外字段", schema: `{"properties": {"a": {}}, "additionalProperties": false}`, value: `{"a": 1, "b": 2}`, wantErr: `不允许的字段 "b"`},
+		{name: "额外字段按 schema 校验", schema: `{"additionalProperties": {"type": "boolean"}}`, value: `{"a": 1}`, wantErr: "$.a"},
+		{name: "items", schema: `{"items": {"type": "string"}}`, value: `["a", 2]`, wantErr: "$[1]"},
+		{name: "minItems", schema: `{"minItems": 2}`, value: `[1]`, wantErr: "至少需要"},
+		{name: "maxItems", schema: `{"maxItems": 1}`, value: `[1, 2]`, wantErr: "最多"},
+		{name: "长度按字符计算", schema: `{"maxLength": 2}`, value: `"你好"`},
+		{name: "minLength", schema: `{"minLength": 3}`, value: `"ab"`, wantErr: "长度至少"},
+		{name: "pattern", schema: `{"pattern": "^[a-z]+$"}`, value: `"abc1"`, wantErr: "不匹配"},
+		{name: "minimum", schema: `{"minimum": 0}`, value: `-1`, wantErr: "不小于"},
+		{name: "maximum 边界", schema: `{"maximum": 10}`, value: `10`},
+		{name: "anyOf", schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, value: `1`},
+		{name: "anyOf 都不符合", schema: `{"anyOf": [{"type": "string"}, {"type": "number"}]}`, value: `true`, wantErr: "anyOf"},
+		{name: "oneOf 符合多项", schema: `{"oneOf": [{"type": "number"}, {"minimum": 0}]}`, value: `1`, wantErr: "实际符合 2 项"},
+		{name: "allOf", schema: `{"allOf": [{"type": "number"}, {"minimum": 5}]}`, value: `3`, wantErr: "不小于"},
+		{name: "$defs 引用", schema: `{"$defs": {"id": {"type": "integer"}}, "items": {"$ref": "#/$defs/id"}}`, value: `[1, "2"]`, wantErr: "$[1]"},
+		{name: "递归引用", schema: `{"properties": {"child": {"$ref": "#"}}, "required": ["v"]}`, value: `{"v": 1, "child": {}}`, wantErr: "$.child"},
+		{name: "无法解析的引用", schema: `{"$ref": "#/definitions/none"}`, value: `1`, wantErr: "无法解析 $ref"},
+		{name: "不认识的关键字忽略", schema: `{"format": "email", "title": "x"}`, value: `"abc"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schema, err := newJSONSchema(json.RawMessage(tt.schema))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var v interface{}
+			dec := json.NewDecoder(strings.NewReader(tt.value))
+			dec.UseNumber()
+			if err := dec.Decode(&v); err != nil {
+				t.Fatal(err)
+			}
+			err = schema.Validate(v)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Validate(%s) = %v, want nil", tt.value, err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Validate(%s) = %v, want %q", tt.value, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNewJSONSchemaRejectsNonObject(t *testing.T) {
+	if _, err := newJSONSchema(json.RawMessage(`[1]`)); err == nil {
+		t.Error("newJSONSchema([1]) 应返回错误")
+	}
+}
@@ -0,0 +1,52 @@
+package core
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Sider token 是 JWT, 这里只解码 payload 读取有效期, 不校验签名
+type jwtClaims struct {
+	Exp int64 `json:"exp"`
+	Iat int64 `json:"iat"`
+}
+
+func decodeJWTClaims(token string) (jwtClaims, error) {
+	var claims jwtClaims
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return claims, fmt.Errorf("不是 JWT 格式")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return claims, fmt.Errorf("解码 payload 失败: %v", err)
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, fmt.Errorf("解析 payload 失败: %v", err)
+	}
+	return claims, nil
+}
+
+// ExpiresAt 返回过期时间, 没有 exp 声明时为零值
+func (c jwtClaims) ExpiresAt() time.Time {
+	if c.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.Exp, 0)
+}
+
+// IssuedAt 返回签发时间, 没有 iat 声明时为零值
+func (c jwtClaims) IssuedAt() time.Time {
+	if c.Iat == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.Iat, 0)
+}
+
+// daysUntil 返回距 t 的整天数, 已过期时为负数
+func daysUntil(t time.Time) int {
+	return int(time.Until(t) / (24 * time.Hour))
+}
@@ -0,0 +1,318 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// APIKey 是一个客户端访问本服务的 Key 及其元数据
+type APIKey struct {
+	Key           string     `json:"key"`
+	Name          string     `json:"name"`
+	AllowedModels []string   `json:"allowed_models,omitempty"` // 为空时不限制, 支持 "claude-*" 形式的前缀匹配
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	Enabled       *bool      `json:"enabled,omitempty"` // 缺省为启用
+	Quota         int64      `json:"quota,omitempty"`   // 允许的补全请求总数, 0 表示不限
+	Used          int64      `json:"used"`
+	RPM           int        `json:"rpm,omitempty"` // 每分钟请求数限额, 0 时使用 KEY_RATE_LIMIT_RPM
+	TPM           int        `json:"tpm,omitempty"` // 每分钟估算 token 数限额, 0 时使用 KEY_RATE_LIMIT_TPM
+
+	fromEnv bool // 来自 API_KEYS / AUTH_TOKEN, 不写入 Key 文件, 也不能通过管理接口修改
+}
+
+// IsEnabled 返回 Key 是否启用
+func (k *APIKey) IsEnabled() bool {
+	return k.Enabled == nil || *k.Enabled
+}
+
+// Expired 判断 Key 是否已过期
+func (k *APIKey) Expired() bool {
+	return k.ExpiresAt != nil && time.Now().After(*k.ExpiresAt)
+}
+
+// AllowsModel 判断 Key 是否可以使用该模型
+func (k *APIKey) AllowsModel(model string) bool {
+	if len(k.AllowedModels) == 0 {
+		return true
+	}
+	for _, allowed := range k.AllowedModels {
+		if allowed == "*" || allowed == model ||
+			(strings.HasSuffix(allowed, "*") && strings.HasPrefix(model, strings.TrimSuffix(allowed, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// keyUsageFlushInterval 是已用次数写回 KEYS_FILE 的间隔, 期间的多次请求合并为一次写入
+const keyUsageFlushInterval = 5 * time.Second
+
+// KeyStore 保存所有客户端 Key, 来源为 KEYS_FILE 指向的 JSON 文件、API_KEYS 与 AUTH_TOKEN
+type KeyStore struct {
+	mu     sync.Mutex
+	keys   map[string]*APIKey
+	dirty  bool       // 有未写入文件的已用次数
+	saveMu sync.Mutex // 串行化文件写入, 避免较旧的快照覆盖较新的
+}
+
+// NewKeyStore 按配置加载客户端 Key
+func NewKeyStore(cfg Config) (*KeyStore, error) {
+	ks := &KeyStore{keys: make(map[string]*APIKey)}
+
+	if cfg.KeysFile != "" {
+		data, err := os.ReadFile(cfg.KeysFile)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("读取 Key 文件失败: %v", err)
+		}
+		var keys []*APIKey
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &keys); err != nil {
+				return nil, fmt.Errorf("解析 Key 文件失败: %v", err)
+			}
+		}
+		for _, k := range keys {
+			ks.add(k)
+		}
+	}
+
+	// API_KEYS 每项为 key 或 name=key
+	for i, entry := range cfg.APIKeys {
+		name, key, ok := strings.Cut(entry, "=")
+		if !ok {
+			name, key = fmt.Sprintf("key-%d", i+1), entry
+		}
+		ks.add(&APIKey{Key: strings.TrimSpace(key), Name: strings.TrimSpace(name), fromEnv: true})
+	}
+	if cfg.AuthToken != "" {
+		ks.add(&APIKey{Key: cfg.AuthToken, Name: "default", fromEnv: true})
+	}
+	return ks, nil
+}
+
+func (ks *KeyStore) add(k *APIKey) {
+	if k.Key == "" {
+		return
+	}
+	if _, exists := ks.keys[k.Key]; exists {
+		return
+	}
+	if k.Name == "" {
+		k.Name = "key-" + hashText(k.Key)[:8]
+	}
+	ks.keys[k.Key] = k
+}
+
+// List 返回全部 Key 的副本, 按名称排序
+func (ks *KeyStore) List() []APIKey {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	list := make([]APIKey, 0, len(ks.keys))
+	for _, k := range ks.keys {
+		list = append(list, *k)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// byName 按名称查找 Key, 需在持有锁时调用
+func (ks *KeyStore) byName(name string) *APIKey {
+	for _, k := range ks.keys {
+		if k.Name == name {
+			return k
+		}
+	}
+	return nil
+}
+
+// Create 新增 Key, 未指定 key 时随机生成. 名称或 key 已存在时返回错误
+func (ks *KeyStore) Create(k APIKey) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	if k.Key == "" {
+		k.Key = "sk-" + randomHex(24)
+	}
+	if k.Name == "" {
+		k.Name = "key-" + hashText(k.Key)[:8]
+	}
+	if _, exists := ks.keys[k.Key]; exists {
+		return APIKey{}, fmt.Errorf("该 key 已存在")
+	}
+	if ks.byName(k.Name) != nil {
+		return APIKey{}, fmt.Errorf("名称 %s 已被使用", k.Name)
+	}
+	k.fromEnv = false
+	ks.keys[k.Key] = &k
+	return k, nil
+}
+
+// Update 按名称替换 Key 的元数据. k.Key 为空时保留原 key, 否则改用新 key.
+// 已用次数保留当前值 (期间并发请求的计数不会被覆盖), setUsed 时改为 k.Used
+func (ks *KeyStore) Update(name string, k APIKey, setUsed bool) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	old := ks.byName(name)
+	if old == nil {
+		return APIKey{}, fmt.Errorf("Key %s 不存在", name)
+	}
+	if old.fromEnv {
+		return APIKey{}, fmt.Errorf("Key %s 来自环境变量, 请修改环境变量", name)
+	}
+	if k.Name == "" {
+		k.Name = old.Name
+	}
+	if k.Name != old.Name && ks.byName(k.Name) != nil {
+		return APIKey{}, fmt.Errorf("名称 %s 已被使用", k.Name)
+	}
+	if k.Key == "" {
+		k.Key = old.Key
+	}
+	if _, exists := ks.keys[k.Key]; exists && k.Key != old.Key {
+		return APIKey{}, fmt.Errorf("该 key 已存在")
+	}
+	if !setUsed {
+		k.Used = old.Used
+	}
+	delete(ks.keys, old.Key)
+	ks.keys[k.Key] = &k
+	return k, nil
+}
+
+// Delete 按名称删除 Key, 返回被删除的 Key
+func (ks *KeyStore) Delete(name string) (APIKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k := ks.byName(name)
+	if k == nil {
+		return APIKey{}, fmt.Errorf("Key %s 不存在", name)
+	}
+	if k.fromEnv {
+		return APIKey{}, fmt.Errorf("Key %s 来自环境变量, 请修改环境变量", name)
+	}
+	delete(ks.keys, k.Key)
+	return *k, nil
+}
+
+// Save 将非环境变量来源的 Key (含已用次数) 写入 JSON 文件
+func (ks *KeyStore) Save(path string) error {
+	ks.saveMu.Lock()
+	defer ks.saveMu.Unlock()
+
+	ks.mu.Lock()
+	ks.dirty = false
+	ks.mu.Unlock()
+
+	keys := []APIKey{}
+	for _, k := range ks.List() {
+		if !k.fromEnv {
+			keys = append(keys, k)
+		}
+	}
+	if err := writeJSONFile(path, keys); err != nil {
+		ks.mu.Lock()
+		ks.dirty = true
+		ks.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// FlushUsage 在已用次数有变化时写入 JSON 文件
+func (ks *KeyStore) FlushUsage(path string) error {
+	ks.mu.Lock()
+	dirty := ks.dirty
+	ks.mu.Unlock()
+	if !dirty {
+		return nil
+	}
+	return ks.Save(path)
+}
+
+// persistUsage 定期将已用次数写回 KEYS_FILE, 重启后配额继续累计
+func (ks *KeyStore) persistUsage(path string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if err := ks.FlushUsage(path); err != nil {
+			fmt.Printf("保存 Key 已用次数失败: %v\n", err)
+		}
+	}
+}
+
+// FromEnv 返回 Key 是否来自环境变量
+func (k *APIKey) FromEnv() bool {
+	return k.fromEnv
+}
+
+// Len 返回 Key 数量, 为 0 时不做认证 (除非 REQUIRE_AUTH)
+func (ks *KeyStore) Len() int {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return len(ks.keys)
+}
+
+// Lookup 返回 Key 的副本
+func (ks *KeyStore) Lookup(key string) (APIKey, bool) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k, ok := ks.keys[key]
+	if !ok {
+		return APIKey{}, false
+	}
+	return *k, true
+}
+
+// Consume 记录一次补全请求, 超出配额时返回 false
+func (ks *KeyStore) Consume(key string) bool {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	k, ok := ks.keys[key]
+	if !ok {
+		return true
+	}
+	if k.Quota > 0 && k.Used >= k.Quota {
+		return false
+	}
+	k.Used++
+	if !k.fromEnv {
+		ks.dirty = true
+	}
+	return true
+}
+
+type contextKey int
+
+const identityContextKey contextKey = iota
+
+// withIdentity 将认证得到的 Key 附加到请求上下文
+func withIdentity(r *http.Request, k *APIKey) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), identityContextKey, k))
+}
+
+// identityFrom 返回请求对应的 Key, 未启用认证时为 nil
+func identityFrom(r *http.Request) *APIKey {
+	k, _ := r.Context().Value(identityContextKey).(*APIKey)
+	return k
+}
+
+// identityName 返回用于日志与统计的调用方名称
+func identityName(r *http.Request) string {
+	if k := identityFrom(r); k != nil {
+		return k.Name
+	}
+	return "anonymous"
+}
@@ -0,0 +1,370 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+// 模型能力
+const (
+	CapabilityChat      = "chat"
+	CapabilityReasoning = "reasoning"
+	CapabilityImage     = "image_generation"
+)
+
+// ModelInfo 是注册表中的一个模型
+type ModelInfo struct {
+	ID            string   `json:"id"`
+	Upstream      string   `json:"upstream,omitempty"` // 发往 Sider 的模型名, 缺省与 id 相同
+	OwnedBy       string   `json:"owned_by,omitempty"`
+	Think         bool     `json:"think,omitempty"` // 默认开启上游 think 模式
+	Capabilities  []string `json:"capabilities,omitempty"`
+	ContextLength int      `json:"context_length,omitempty"` // 上下文窗口 (token), 0 表示未知
+	Encoding      string   `json:"encoding,omitempty"`       // 计算 token 的编码 cl100k_base / o200k_base, 缺省按模型判断
+	Aliases       []string `json:"aliases,omitempty"`
+	Fallbacks     []string `json:"fallbacks,omitempty"` // 上游拒绝或出错时依次改用的模型 (id 或别名)
+	Description   string   `json:"description,omitempty"`
+
+	// 兼容 deno_pro.ts 写入的 custom_models.json ({"id", "model", "description"})
+	Model string `json:"model,omitempty"`
+}
+
+// Has 判断模型是否具备某项能力
+func (m *ModelInfo) Has(capability string) bool {
+	for _, c := range m.Capabilities {
+		if c == capability {
+			return true
+		}
+	}
+	return false
+}
+
+// normalize 填充缺省值
+func (m *ModelInfo) normalize() {
+	if m.Upstream == "" {
+		m.Upstream = m.Model
+	}
+	if m.Upstream == "" {
+		m.Upstream = m.ID
+	}
+	m.Model = ""
+	if m.OwnedBy == "" {
+		m.OwnedBy = "sider"
+	}
+	if len(m.Capabilities) == 0 {
+		m.Capabilities = []string{CapabilityChat}
+	}
+}
+
+// 未配置模型文件 (或文件为空) 时使用的内置模型
+var builtinModels = []ModelInfo{
+	{ID: "gpt-4o", OwnedBy: "openai", Capabilities: []string{CapabilityChat}, ContextLength: 128000},
+	{ID: "gpt-4.1", OwnedBy: "openai", Capabilities: []string{CapabilityChat}, ContextLength: 1047576},
+	{ID: "gpt-4.1-mini", OwnedBy: "openai", Capabilities: []string{CapabilityChat}, ContextLength: 1047576},
+	{ID: "gpt-4.5", OwnedBy: "openai", Capabilities: []string{CapabilityChat}, ContextLength: 128000},
+	{ID: "o1", OwnedBy: "openai", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 200000},
+	{ID: "o3", OwnedBy: "openai", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 200000},
+	{ID: "o3-mini", OwnedBy: "openai", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 200000},
+	{ID: "o4-mini", OwnedBy: "openai", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 200000},
+	{ID: "claude-3.7-sonnet", OwnedBy: "anthropic", Capabilities: []string{CapabilityChat}, ContextLength: 200000,
+		Aliases: []string{"claude-3-7-sonnet-20250219", "claude-3-7-sonnet-latest"}},
+	{ID: "claude-4-sonnet", OwnedBy: "anthropic", Capabilities: []string{CapabilityChat}, ContextLength: 200000,
+		Aliases: []string{"claude-sonnet-4-20250514", "claude-sonnet-4-0"}},
+	{ID: "claude-4-sonnet-think", OwnedBy: "anthropic", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 200000},
+	{ID: "claude-4-opus", OwnedBy: "anthropic", Capabilities: []string{CapabilityChat}, ContextLength: 200000,
+		Aliases: []string{"claude-opus-4-20250514", "claude-opus-4-0"}},
+	{ID: "claude-4-opus-think", OwnedBy: "anthropic", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 200000},
+	{ID: "deepseek-reasoner", OwnedBy: "deepseek", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 64000},
+	{ID: "llama-3.1-405b", OwnedBy: "meta", ContextLength: 128000},
+	{ID: "gemini-2.0-pro", OwnedBy: "google", Capabilities: []string{CapabilityChat}, ContextLength: 1048576},
+	{ID: "gemini-2.5-pro", OwnedBy: "google", Capabilities: []string{CapabilityChat}, ContextLength: 1048576},
+	{ID: "gemini-2.5-flash", OwnedBy: "google", Capabilities: []string{CapabilityChat}, ContextLength: 1048576},
+	{ID: "gemini-2.5-pro-think", OwnedBy: "google", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 1048576},
+	{ID: "gemini-2.5-flash-think", OwnedBy: "google", Think: true, Capabilities: []string{CapabilityChat, CapabilityReasoning}, ContextLength: 1048576},
+	{ID: "dalle_3_HD", OwnedBy: "openai", Capabilities: []string{CapabilityImage}, Aliases: []string{"dall-e-3"}},
+	{ID: "flux-pro-1.1", OwnedBy: "black-forest-labs", Capabilities: []string{CapabilityImage}},
+	{ID: "flux-pro-1.1-ultra", OwnedBy: "black-forest-labs", Capabilities: []string{CapabilityImage}},
+	{ID: "ideogram_v2", OwnedBy: "ideogram", Capabilities: []string{CapabilityImage}},
+	{ID: "sd3.5-large", OwnedBy: "stability", Capabilities: []string{CapabilityImage}},
+	{ID: "sdxlV1.0", OwnedBy: "stability", Capabilities: []string{CapabilityImage}},
+}
+
+// ModelRegistry 保存可用模型, 按 id 与别名查找.
+// 模型文件中定义了模型时以文件为准, 否则使用内置模型.
+type ModelRegistry struct {
+	mu     sync.RWMutex
+	models []*ModelInfo
+	index  map[string]*ModelInfo // id 与别名 -> 模型
+}
+
+// NewModelRegistry 从 JSON 文件加载模型 (文件不存在或为空数组时使用内置模型),
+// 再应用 MODEL_ALIASES 与 MODEL_FALLBACKS
+func NewModelRegistry(cfg Config) (*ModelRegistry, error) {
+	models := builtinModels
+	if path := cfg.ModelsFile; path != "" {
+		data, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			fmt.Printf("未找到模型文件 %s, 使用内置模型\n", path)
+		case err != nil:
+			return nil, fmt.Errorf("读取模型文件失败: %v", err)
+		default:
+			var loaded []ModelInfo
+			if err := json.Unmarshal(data, &loaded); err != nil {
+				return nil, fmt.Errorf("解析模型文件 %s 失败: %v", path, err)
+			}
+			if len(loaded) > 0 {
+				models = loaded
+				fmt.Printf("从 %s 加载了 %d 个模型\n", path, len(loaded))
+			}
+		}
+	}
+
+	models, err := applyModelOverrides(models, cfg.ModelAliases, cfg.ModelFallbacks)
+	if err != nil {
+		return nil, err
+	}
+	r := &ModelRegistry{}
+	if err := r.set(models); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// applyModelOverrides 应用环境变量中的别名 (alias=target) 与回退链 (model=a|b).
+// 别名可以与已有模型同名, 用于将下线的模型整体指向新模型, 此时旧模型从注册表中移除.
+func applyModelOverrides(models []ModelInfo, aliases, fallbacks []string) ([]ModelInfo, error) {
+	if len(aliases) == 0 && len(fallbacks) == 0 {
+		return models, nil
+	}
+	models = append([]ModelInfo(nil), models...)
+	find := func(name string) int {
+		for i, m := range models {
+			if m.ID == name {
+				return i
+			}
+			for _, alias := range m.Aliases {
+				if alias == name {
+					return i
+				}
+			}
+		}
+		return -1
+	}
+
+	for _, entry := range aliases {
+		alias, target, ok := strings.Cut(entry, "=")
+		alias, target = strings.TrimSpace(alias), strings.TrimSpace(target)
+		if !ok || alias == "" || target == "" {
+			return nil, fmt.Errorf("MODEL_ALIASES 格式错误: %s (应为 alias=model)", entry)
+		}
+		i := find(target)
+		if i < 0 {
+			return nil, fmt.Errorf("别名 %s 指向的模型 %s 不存在", alias, target)
+		}
+		if j := find(alias); j == i {
+			continue // 已保存到模型文件中
+		} else if j >= 0 {
+			if models[j].ID != alias {
+				return nil, fmt.Errorf("别名 %s 已属于模型 %s", alias, models[j].ID)
+			}
+			models = append(models[:j], models[j+1:]...)
+			if j < i {
+				i--
+			}
+		}
+		m := models[i]
+		m.Aliases = append(append([]string(nil), m.Aliases...), alias)
+		models[i] = m
+	}
+
+	for _, entry := range fallbacks {
+		name, list, ok := strings.Cut(entry, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("MODEL_FALLBACKS 格式错误: %s (应为 model=a|b)", entry)
+		}
+		i := find(name)
+		if i < 0 {
+			return nil, fmt.Errorf("回退链中的模型 %s 不存在", name)
+		}
+		var chain []string
+		for _, fb := range strings.Split(list, "|") {
+			if fb = strings.TrimSpace(fb); fb != "" {
+				chain = append(chain, fb)
+			}
+		}
+		models[i].Fallbacks = chain
+	}
+	return models, nil
+}
+
+// set 替换全部模型, id 或别名重复时返回错误
+func (r *ModelRegistry) set(models []ModelInfo) error {
+	list, index, err := buildModelIndex(models)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.models, r.index = list, index
+	return nil
+}
+
+// buildModelIndex 填充缺省值并建立 id/别名索引, 同时校验回退链
+func buildModelIndex(models []ModelInfo) ([]*ModelInfo, map[string]*ModelInfo, error) {
+	list := make([]*ModelInfo, 0, len(models))
+	index := make(map[string]*ModelInfo)
+	for i := range models {
+		m := models[i]
+		m.ID = strings.TrimSpace(m.ID)
+		if m.ID == "" {
+			return nil, nil, fmt.Errorf("第 %d 个模型缺少 id", i+1)
+		}
+		m.normalize()
+		for _, name := range append([]string{m.ID}, m.Aliases...) {
+			if _, exists := index[name]; exists {
+				return nil, nil, fmt.Errorf("模型名 %s 重复", name)
+			}
+			index[name] = &m
+		}
+		list = append(list, &m)
+	}
+
+	for _, m := range list {
+		for _, fb := range m.Fallbacks {
+			if _, ok := index[fb]; !ok {
+				return nil, nil, fmt.Errorf("模型 %s 的回退模型 %s 不存在", m.ID, fb)
+			}
+		}
+	}
+	return list, index, nil
+}
+
+// update 在持有写锁时修改模型列表, 修改结果校验通过后才生效
+func (r *ModelRegistry) update(fn func(models []ModelInfo) ([]ModelInfo, error)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	models := make([]ModelInfo, len(r.models))
+	for i, m := range r.models {
+		models[i] = *m
+	}
+	models, err := fn(models)
+	if err != nil {
+		return err
+	}
+	list, index, err := buildModelIndex(models)
+	if err != nil {
+		return err
+	}
+	r.models, r.index = list, index
+	return nil
+}
+
+// Put 新增 (create 为 true) 或替换同 id 的模型
+func (r *ModelRegistry) Put(m ModelInfo, create bool) error {
+	return r.update(func(models []ModelInfo) ([]ModelInfo, error) {
+		for i := range models {
+			if models[i].ID == m.ID {
+				if create {
+					return nil, fmt.Errorf("模型 %s 已存在", m.ID)
+				}
+				models[i] = m
+				return models, nil
+			}
+		}
+		if !create {
+			return nil, fmt.Errorf("模型 %s 不存在", m.ID)
+		}
+		return append(models, m), nil
+	})
+}
+
+// Delete 删除模型, 仍被其他模型的回退链引用时返回错误
+func (r *ModelRegistry) Delete(id string) error {
+	return r.update(func(models []ModelInfo) ([]ModelInfo, error) {
+		for i := range models {
+			if models[i].ID == id {
+				return append(models[:i], models[i+1:]...), nil
+			}
+		}
+		return nil, fmt.Errorf("模型 %s 不存在", id)
+	})
+}
+
+// Save 将全部模型写入 JSON 文件
+func (r *ModelRegistry) Save(path string) error {
+	return writeJSONFile(path, r.List())
+}
+
+// Resolve 按 id 或别名查找模型
+func (r *ModelRegistry) Resolve(name string) (ModelInfo, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	m, ok := r.index[name]
+	if !ok {
+		return ModelInfo{}, false
+	}
+	return *m, true
+}
+
+// Chain 返回模型本身及其回退链上的模型, 去重并保持顺序
+func (r *ModelRegistry) Chain(m ModelInfo) []ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	chain := []ModelInfo{m}
+	seen := map[string]bool{m.ID: true}
+	for _, name := range m.Fallbacks {
+		fb, ok := r.index[name]
+		if !ok || seen[fb.ID] {
+			continue
+		}
+		seen[fb.ID] = true
+		chain = append(chain, *fb)
+	}
+	return chain
+}
+
+// isModelError 判断上游错误是否可能与所选模型有关, 此时改用回退模型重试.
+// 账号错误由账号池换账号处理, 超出上下文长度等请求本身的错误换模型也无济于事.
+func isModelError(apiErr *apiError) bool {
+	return apiErr.Type == "upstream_error"
+}
+
+// List 按配置顺序返回全部模型的副本
+func (r *ModelRegistry) List() []ModelInfo {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]ModelInfo, 0, len(r.models))
+	for _, m := range r.models {
+		list = append(list, *m)
+	}
+	return list
+}
+
+// IDs 返回全部模型 id
+func (r *ModelRegistry) IDs() []string {
+	list := r.List()
+	ids := make([]string, len(list))
+	for i, m := range list {
+		ids[i] = m.ID
+	}
+	return ids
+}
+
+// modelNotFound 是请求了注册表中不存在的模型时的错误
+func modelNotFound(model string) *apiError {
+	return &apiError{Status: http.StatusNotFound, Type: "invalid_request_error", Code: "model_not_found",
+		Message: fmt.Sprintf("模型 %s 不存在, 可用模型见 /v1/models", model)}
+}
@@ -0,0 +1,239 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// forwardToSider 将 OpenAI Chat Completions 请求转发到 Sider 并以 OpenAI 格式返回
+func (s *Server) forwardToSider(w http.ResponseWriter, r *http.Request, userReq *UserRequest) {
+	tc, apiErr := s.prepareTools(userReq)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	format, apiErr := prepareResponseFormat(userReq)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	if format != nil {
+		s.forwardStructured(w, r, userReq, tc, format)
+		return
+	}
+	c, apiErr := s.startCompletion(r, userReq)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	defer c.finish()
+
+	// 同一次补全的所有分块共用一个 ID
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+	usage := func() OpenAIUsage {
+		prompt, completion := c.PromptTokens(), c.CompletionTokens()
+		return OpenAIUsage{
+			PromptTokens:     prompt,
+			CompletionTokens: completion,
+			TotalTokens:      prompt + completion,
+			CreditInfo:       c.Credits(),
+		}
+	}
+
+	c.setHeaders(w)
+
+	if !c.Stream {
+		// 非流式响应
+		if err := c.each(func(SiderEvent) bool { return true }); err != nil {
+			fmt.Printf("读取响应失败: %v\n", err)
+			writeAPIError(w, toAPIError(err))
+			return
+		}
+
+		message := Message{Role: "assistant", Content: c.Text(), ReasoningContent: c.Reasoning()}
+		finishReason := c.FinishReason()
+		if tc != nil {
+			content, calls, err := tc.parse(message.Content)
+			switch {
+			case err != nil && tc.fallback == ToolFallbackError:
+				fmt.Printf("解析函数调用失败: %v\n", err)
+				writeAPIError(w, toolParseError(err))
+				return
+			case err != nil:
+				fmt.Printf("解析函数调用失败, 按普通回复返回: %v\n", err)
+			case len(calls) > 0:
+				message.Content, message.ToolCalls = content, calls
+				finishReason = "tool_calls"
+			}
+		}
+
+		c.setReply(message)
+		openAIResp := OpenAIResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   c.Model,
+			Choices: []OpenAIChoice{
+				{
+					Message:      message,
+					FinishReason: finishReason,
+					Index:        0,
+				},
+			},
+			Usage: usage(),
+		}
+
+		w.Header().Set("X-Conversation-ID", c.ConversationID())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(openAIResp)
+		return
+	}
+
+	// 流式响应
+	sendChunk := func(chunk OpenAIStreamResponse) bool {
+		chunk.ID = id
+		chunk.Object = "chat.completion.chunk"
+		chunk.Created = created
+		chunk.Model = c.Model
+
+		openAIJSON, err := json.Marshal(chunk)
+		if err != nil {
+			fmt.Printf("转换OpenAI格式失败: %v\n", err)
+			return true
+		}
+		if _, err := w.Write([]byte("data: " + string(openAIJSON) + "\n\n")); err != nil {
+			fmt.Printf("写入响应失败: %v\n", err)
+			return false
+		}
+		flush(w)
+		return true
+	}
+	sendDelta := func(delta OpenAIDelta, finishReason *string) bool {
+		return sendChunk(OpenAIStreamResponse{
+			Choices: []OpenAIStreamChoice{{Delta: delta, FinishReason: finishReason, Index: 0}},
+		})
+	}
+
+	// 响应头推迟到第一段输出时再写, 这样开头的上游错误仍能以正确的 HTTP 状态码返回.
+	// 第一个分块只携带 role.
+	started := false
+	startStream := func() bool {
+		if started {
+			return true
+		}
+		started = true
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		return sendDelta(OpenAIDelta{Role: "assistant"}, nil)
+	}
+
+	// 模拟函数调用时扣住调用块, 结束后再解析为 tool_calls. sent 记录已输出的正文, 保存会话时使用
+	var ts toolStream
+	var sent strings.Builder
+	err := c.each(func(ev SiderEvent) bool {
+		// 正文 (含图片链接) 写入 content, 思考过程写入 reasoning_content, pulse 转为心跳
+		var delta OpenAIDelta
+		switch ev.Type {
+		case EventPulse:
+			return startStream() && writeKeepAlive(w)
+		case EventReasoning:
+			delta.ReasoningContent = ev.Text
+		default:
+			delta.Content = ev.Content()
+			if tc != nil {
+				delta.Content = ts.feed(delta.Content)
+			}
+		}
+		if delta.Content == "" && delta.ReasoningContent == "" {
+			return true
+		}
+		sent.WriteString(delta.Content)
+		return startStream() && sendDelta(delta, nil)
+	})
+	if err != nil {
+		fmt.Printf("读取响应失败: %v\n", err)
+		apiErr := toAPIError(err)
+		if !started {
+			writeAPIError(w, apiErr)
+			return
+		}
+		// 已开始输出时以 OpenAI 流内错误的形式告知客户端
+		payload, _ := json.Marshal(newOpenAIError(apiErr.Type, apiErr.Message, apiErr.Code))
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+		flush(w)
+		return
+	}
+
+	startStream()
+	finishReason := c.FinishReason()
+	var calls []OpenAIToolCall
+	if tc != nil {
+		var err error
+		_, calls, err = tc.parse(ts.held)
+		switch {
+		case err != nil && tc.fallback == ToolFallbackError:
+			fmt.Printf("解析函数调用失败: %v\n", err)
+			apiErr := toolParseError(err)
+			payload, _ := json.Marshal(newOpenAIError(apiErr.Type, apiErr.Message, apiErr.Code))
+			fmt.Fprintf(w, "data: %s\n\n", payload)
+			flush(w)
+			return
+		case err != nil:
+			fmt.Printf("解析函数调用失败, 按普通回复返回: %v\n", err)
+			sendDelta(OpenAIDelta{Content: ts.held}, nil)
+			sent.WriteString(ts.held)
+			calls = nil
+		case len(calls) > 0:
+			for i := range calls {
+				index := i
+				calls[i].Index = &index
+			}
+			sendDelta(OpenAIDelta{ToolCalls: calls}, nil)
+			finishReason = "tool_calls"
+		case ts.held != "":
+			sendDelta(OpenAIDelta{Content: ts.held}, nil)
+			sent.WriteString(ts.held)
+		}
+	}
+	c.setReply(Message{Role: "assistant", Content: sent.String(), ToolCalls: calls})
+	sendDelta(OpenAIDelta{}, &finishReason)
+	if userReq.StreamOptions != nil && userReq.StreamOptions.IncludeUsage {
+		u := usage()
+		sendChunk(OpenAIStreamResponse{Choices: []OpenAIStreamChoice{}, Usage: &u})
+	}
+
+	fmt.Println("响应结束")
+	if _, err := w.Write([]byte("data: [DONE]\n\n")); err != nil {
+		fmt.Printf("写入DONE失败: %v\n", err)
+	}
+	flush(w)
+}
+
+func newOpenAIError(errType, message, code string) OpenAIError {
+	resp := OpenAIError{Error: OpenAIErrorDetail{Message: message, Type: errType}}
+	if code != "" {
+		resp.Error.Code = &code
+	}
+	return resp
+}
+
+// writeOpenAIError 以 OpenAI 的 {"error":{...}} 格式返回错误, code 为空时输出 null
+func writeOpenAIError(w http.ResponseWriter, status int, errType, message, code string) {
+	resp := newOpenAIError(errType, message, code)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeAPIError 以 OpenAI 格式返回内部错误, 限流时附带 Retry-After
+func writeAPIError(w http.ResponseWriter, apiErr *apiError) {
+	setErrorHeaders(w, apiErr)
+	writeOpenAIError(w, apiErr.Status, apiErr.Type, apiErr.Message, apiErr.Code)
+}
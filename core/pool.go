@@ -0,0 +1,411 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 账号池的选择策略
+const (
+	StrategyRoundRobin = "round-robin"
+	StrategyLeastUsed  = "least-used"
+	StrategyWeighted   = "weighted"
+)
+
+// upstreamAccount 是池中的一个 Sider 账号
+type upstreamAccount struct {
+	Token     string
+	Weight    int
+	ExpiresAt time.Time // 从 JWT 的 exp 解析, 零值表示未知
+	IssuedAt  time.Time
+	fromEnv   bool // 来自 SIDER_AUTH_TOKEN(S), 不写入 token 文件, 也不能通过管理接口修改
+
+	inFlight      int
+	requests      int64
+	failures      int64
+	cooldownUntil time.Time
+	lastError     string
+	currentWeight int // 平滑加权轮询的当前权重
+}
+
+// Name 返回由 token 摘要生成的账号名, 用于日志与状态展示 (JWT 的开头都相同, 不适合截取)
+func (a *upstreamAccount) Name() string {
+	return "tok-" + hashText(a.Token)[:8]
+}
+
+// expired 判断 token 是否已过期
+func (a *upstreamAccount) expired(now time.Time) bool {
+	return !a.ExpiresAt.IsZero() && !now.Before(a.ExpiresAt)
+}
+
+// AccountStatus 是账号状态的快照
+type AccountStatus struct {
+	Name            string     `json:"name"`
+	Weight          int        `json:"weight"`
+	InFlight        int        `json:"in_flight"`
+	Requests        int64      `json:"requests"`
+	Failures        int64      `json:"failures"`
+	Healthy         bool       `json:"healthy"`
+	Expired         bool       `json:"expired"`
+	ExpiresAt       *time.Time `json:"expires_at,omitempty"`
+	IssuedAt        *time.Time `json:"issued_at,omitempty"`
+	DaysUntilExpiry *int       `json:"days_until_expiry,omitempty"`
+	CooldownUntil   *time.Time `json:"cooldown_until,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	Source          string     `json:"source"` // env / file
+}
+
+// TokenPool 管理多个上游账号: 按策略选择账号, 账号出现认证/限流错误时冷却一段时间
+type TokenPool struct {
+	mu       sync.Mutex
+	accounts []*upstreamAccount
+	strategy string
+	cooldown time.Duration
+	next     int              // 轮询位置
+	now      func() time.Time // 时钟, 测试时替换
+}
+
+// NewTokenPool 创建账号池, entries (来自环境变量) 的每项为 token 或 token:weight, 重复的 token 只保留一个
+func NewTokenPool(entries []string, strategy string, cooldown time.Duration) *TokenPool {
+	return newTokenPool(entries, strategy, cooldown, time.Now)
+}
+
+func newTokenPool(entries []string, strategy string, cooldown time.Duration, now func() time.Time) *TokenPool {
+	p := &TokenPool{strategy: strategy, cooldown: cooldown, now: now}
+	for _, entry := range entries {
+		token, weight := parseTokenEntry(entry)
+		if token == "" || p.find(token) != nil {
+			continue
+		}
+		a := newUpstreamAccount(token, weight)
+		a.fromEnv = true
+		p.accounts = append(p.accounts, a)
+	}
+	return p
+}
+
+// tokenFileEntry 是 token 文件中的一项
+type tokenFileEntry struct {
+	Token  string `json:"token"`
+	Weight int    `json:"weight,omitempty"`
+}
+
+// LoadFile 从 JSON 文件追加账号, 文件不存在时忽略
+func (p *TokenPool) LoadFile(path string) error {
+	if path == "" {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && len(data) == 0) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("读取 token 文件失败: %v", err)
+	}
+	var entries []tokenFileEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return fmt.Errorf("解析 token 文件 %s 失败: %v", path, err)
+	}
+	for _, e := range entries {
+		if _, err := p.Add(e.Token, e.Weight); err != nil {
+			fmt.Printf("跳过 token 文件中的账号: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// Save 将非环境变量来源的账号写入 JSON 文件
+func (p *TokenPool) Save(path string) error {
+	p.mu.Lock()
+	entries := []tokenFileEntry{}
+	for _, a := range p.accounts {
+		if !a.fromEnv {
+			entries = append(entries, tokenFileEntry{Token: a.Token, Weight: a.Weight})
+		}
+	}
+	p.mu.Unlock()
+	return writeJSONFile(path, entries)
+}
+
+// find 按 token 或账号名查找账号, 需在持有锁时调用
+func (p *TokenPool) find(nameOrToken string) *upstreamAccount {
+	for _, a := range p.accounts {
+		if a.Token == nameOrToken || a.Name() == nameOrToken {
+			return a
+		}
+	}
+	return nil
+}
+
+// Add 新增账号, 权重小于 1 时为 1
+func (p *TokenPool) Add(token string, weight int) (AccountStatus, error) {
+	token = strings.TrimSpace(token)
+	if token == "" {
+		return AccountStatus{}, fmt.Errorf("token 不能为空")
+	}
+	if weight < 1 {
+		weight = 1
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.find(token) != nil {
+		return AccountStatus{}, fmt.Errorf("该 token 已存在")
+	}
+	a := newUpstreamAccount(token, weight)
+	if a.expired(p.now()) {
+		return AccountStatus{}, fmt.Errorf("token 已于 %s 过期", a.ExpiresAt.Format("2006-01-02 15:04"))
+	}
+	p.accounts = append(p.accounts, a)
+	fmt.Printf("已添加账号 %s (权重 %d)\n", a.Name(), weight)
+	return p.status(a, p.now()), nil
+}
+
+// SetWeight 修改账号权重
+func (p *TokenPool) SetWeight(name string, weight int) (AccountStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a := p.find(name)
+	if a == nil {
+		return AccountStatus{}, fmt.Errorf("账号 %s 不存在", name)
+	}
+	if a.fromEnv {
+		return AccountStatus{}, fmt.Errorf("账号 %s 来自环境变量, 请修改环境变量", name)
+	}
+	if weight < 1 {
+		weight = 1
+	}
+	a.Weight = weight
+	return p.status(a, p.now()), nil
+}
+
+// Remove 移除账号. 正在使用该账号的请求不受影响
+func (p *TokenPool) Remove(name string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, a := range p.accounts {
+		if a.Name() != name {
+			continue
+		}
+		if a.fromEnv {
+			return fmt.Errorf("账号 %s 来自环境变量, 请修改环境变量", name)
+		}
+		p.accounts = append(p.accounts[:i], p.accounts[i+1:]...)
+		fmt.Printf("已移除账号 %s\n", name)
+		return nil
+	}
+	return fmt.Errorf("账号 %s 不存在", name)
+}
+
+func newUpstreamAccount(token string, weight int) *upstreamAccount {
+	a := &upstreamAccount{Token: token, Weight: weight}
+	if claims, err := decodeJWTClaims(token); err == nil {
+		a.ExpiresAt = claims.ExpiresAt()
+		a.IssuedAt = claims.IssuedAt()
+	} else {
+		fmt.Printf("账号 %s 无法解析有效期: %v\n", a.Name(), err)
+	}
+	return a
+}
+
+// parseTokenEntry 解析 token:weight, 权重缺省或非法时为 1
+func parseTokenEntry(entry string) (string, int) {
+	entry = strings.TrimSpace(entry)
+	if i := strings.LastIndex(entry, ":"); i > 0 {
+		if weight, err := strconv.Atoi(entry[i+1:]); err == nil {
+			if weight < 1 {
+				weight = 1
+			}
+			return entry[:i], weight
+		}
+	}
+	return entry, 1
+}
+
+// Len 返回账号总数
+func (p *TokenPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.accounts)
+}
+
+// Acquire 按策略选择一个健康且不在 exclude 中的账号; preferred 健康时优先使用 (用于会话复用).
+// 没有可用账号时返回 nil 与最早结束冷却的时间.
+func (p *TokenPool) Acquire(preferred string, exclude map[string]bool) (*upstreamAccount, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	var candidates []*upstreamAccount
+	var earliest time.Time
+	for _, a := range p.accounts {
+		if exclude[a.Token] || a.expired(now) {
+			continue
+		}
+		if now.Before(a.cooldownUntil) {
+			if earliest.IsZero() || a.cooldownUntil.Before(earliest) {
+				earliest = a.cooldownUntil
+			}
+			continue
+		}
+		if a.Token == preferred {
+			return p.take(a), time.Time{}
+		}
+		candidates = append(candidates, a)
+	}
+	if len(candidates) == 0 {
+		return nil, earliest
+	}
+
+	var picked *upstreamAccount
+	switch p.strategy {
+	case StrategyLeastUsed:
+		for _, a := range candidates {
+			if picked == nil || a.inFlight < picked.inFlight ||
+				(a.inFlight == picked.inFlight && a.requests < picked.requests) {
+				picked = a
+			}
+		}
+	case StrategyWeighted:
+		// 平滑加权轮询 (nginx 算法)
+		total := 0
+		for _, a := range candidates {
+			a.currentWeight += a.Weight
+			total += a.Weight
+			if picked == nil || a.currentWeight > picked.currentWeight {
+				picked = a
+			}
+		}
+		picked.currentWeight -= total
+	default:
+		picked = candidates[p.next%len(candidates)]
+		p.next++
+	}
+	return p.take(picked), time.Time{}
+}
+
+func (p *TokenPool) take(a *upstreamAccount) *upstreamAccount {
+	a.inFlight++
+	a.requests++
+	return a
+}
+
+// Release 归还账号. apiErr 为账号相关的错误 (认证失败/限流) 时让账号冷却
+func (p *TokenPool) Release(a *upstreamAccount, apiErr *apiError) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	a.inFlight--
+	if apiErr == nil {
+		return
+	}
+	a.failures++
+	a.lastError = apiErr.Message
+	if !isAccountError(apiErr) {
+		return
+	}
+
+	cooldown := p.cooldown
+	if retry := time.Duration(apiErr.RetryAfter) * time.Second; retry > cooldown {
+		cooldown = retry
+	}
+	a.cooldownUntil = p.now().Add(cooldown)
+	fmt.Printf("账号 %s 暂停使用至 %s: %s\n", a.Name(), a.cooldownUntil.Format("15:04:05"), apiErr.Message)
+}
+
+// Status 返回所有账号的状态快照
+func (p *TokenPool) Status() []AccountStatus {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	statuses := make([]AccountStatus, 0, len(p.accounts))
+	for _, a := range p.accounts {
+		statuses = append(statuses, p.status(a, now))
+	}
+	return statuses
+}
+
+// status 生成单个账号的状态快照, 需在持有锁时调用
+func (p *TokenPool) status(a *upstreamAccount, now time.Time) AccountStatus {
+	st := AccountStatus{
+		Name:      a.Name(),
+		Weight:    a.Weight,
+		InFlight:  a.inFlight,
+		Requests:  a.requests,
+		Failures:  a.failures,
+		Expired:   a.expired(now),
+		LastError: a.lastError,
+		Source:    "file",
+	}
+	if a.fromEnv {
+		st.Source = "env"
+	}
+	st.Healthy = !st.Expired && !now.Before(a.cooldownUntil)
+	if now.Before(a.cooldownUntil) {
+		until := a.cooldownUntil
+		st.CooldownUntil = &until
+	}
+	if !a.ExpiresAt.IsZero() {
+		expiresAt, days := a.ExpiresAt, daysUntil(a.ExpiresAt)
+		st.ExpiresAt, st.DaysUntilExpiry = &expiresAt, &days
+	}
+	if !a.IssuedAt.IsZero() {
+		issuedAt := a.IssuedAt
+		st.IssuedAt = &issuedAt
+	}
+	return st
+}
+
+// 距过期不足该天数时在日志中告警
+const tokenExpiryWarnDays = 7
+
+// CheckExpiry 检查并记录每个账号的有效期, 返回未过期的账号数
+func (p *TokenPool) CheckExpiry() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := p.now()
+	valid := 0
+	for _, a := range p.accounts {
+		switch {
+		case a.ExpiresAt.IsZero():
+			valid++
+		case a.expired(now):
+			fmt.Printf("账号 %s 已于 %s 过期, 已停用\n", a.Name(), a.ExpiresAt.Format("2006-01-02 15:04"))
+		default:
+			valid++
+			days := daysUntil(a.ExpiresAt)
+			if days < tokenExpiryWarnDays {
+				fmt.Printf("警告: 账号 %s 将在 %d 天后过期 (%s), 请及时更换\n", a.Name(), days, a.ExpiresAt.Format("2006-01-02 15:04"))
+			} else {
+				fmt.Printf("账号 %s 有效期至 %s (剩余 %d 天)\n", a.Name(), a.ExpiresAt.Format("2006-01-02"), days)
+			}
+		}
+	}
+	return valid
+}
+
+// watchExpiry 定期检查账号有效期
+func (p *TokenPool) watchExpiry(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		p.CheckExpiry()
+	}
+}
+
+// isAccountError 判断错误是否与账号本身有关, 换一个账号重试可能成功
+func isAccountError(apiErr *apiError) bool {
+	return apiErr.Status == http.StatusUnauthorized || apiErr.Status == http.StatusTooManyRequests
+}
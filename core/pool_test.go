@@ -0,0 +1,250 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTokenPoolSelection(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy string
+		entries  []string
+		hold     bool // 不归还已取得的账号, 模拟并发中的请求
+		want     []string
+	}{
+		{name: "轮询", strategy: StrategyRoundRobin, entries: []string{"a", "b", "c"},
+			want: []string{"a", "b", "c", "a", "b", "c"}},
+		{name: "未知策略按轮询", strategy: "random", entries: []string{"a", "b"},
+			want: []string{"a", "b", "a", "b"}},
+		{name: "最少使用: 请求数相同时按顺序", strategy: StrategyLeastUsed, entries: []string{"a", "b", "c"},
+			want: []string{"a", "b", "c", "a", "b", "c"}},
+		{name: "最少使用: 优先进行中请求少的账号", strategy: StrategyLeastUsed, entries: []string{"a", "b"}, hold: true,
+			want: []string{"a", "b", "a", "b"}},
+		// nginx 平滑加权轮询: 权重 5:1:1 时高权重账号的请求分散开, 不连续占满
+		{name: "平滑加权", strategy: StrategyWeighted, entries: []string{"a:5", "b:1", "c:1"},
+			want: []string{"a", "a", "b", "a", "c", "a", "a", "a", "a", "b", "a", "c", "a", "a"}},
+		{name: "权重相同时等同轮询", strategy: StrategyWeighted, entries: []string{"a:2", "b:2"},
+			want: []string{"a", "b", "a", "b"}},
+		{name: "重复的 token 只保留一个", strategy: StrategyRoundRobin, entries: []string{"a", "a:3", "b"},
+			want: []string{"a", "b", "a"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newTokenPool(tt.entries, tt.strategy, time.Minute, newFakeClock().now)
+			var got []string
+			for range tt.want {
+				a, _ := p.Acquire("", nil)
+				if a == nil {
+					t.Fatalf("第 %d 次 Acquire 没有取得账号", len(got)+1)
+				}
+				got = append(got, a.Token)
+				if !tt.hold {
+					p.Release(a, nil)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("选择顺序 = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTokenPoolAcquire(t *testing.T) {
+	p := newTokenPool([]string{"a", "b", "c"}, StrategyRoundRobin, time.Minute, newFakeClock().now)
+
+	if a, _ := p.Acquire("c", nil); a == nil || a.Token != "c" {
+		t.Errorf("preferred 可用时应优先使用, got %v", a)
+	}
+	if a, _ := p.Acquire("", map[string]bool{"a": true}); a == nil || a.Token != "b" {
+		t.Errorf("应跳过 exclude 中的账号, got %v", a)
+	}
+	if a, retryAt := p.Acquire("", map[string]bool{"a": true, "b": true, "c": true}); a != nil || !retryAt.IsZero() {
+		t.Errorf("全部排除时应返回 nil 且没有冷却结束时间, got %v %v", a, retryAt)
+	}
+}
+
+func TestTokenPoolCooldown(t *testing.T) {
+	const cooldown = 5 * time.Minute
+	tests := []struct {
+		name     string
+		err      *apiError
+		cooldown time.Duration // 0 表示不冷却
+	}{
+		{name: "成功", err: nil},
+		{name: "认证失败", err: siderError(siderCodeUnauthorized, "unauthorized"), cooldown: cooldown},
+		{name: "上游限流, 等待时间短于默认冷却", err: siderError(siderCodeRateLimited, "try again after 30 seconds"), cooldown: cooldown},
+		{name: "上游限流, 按提示的等待时间冷却", err: siderError(siderCodeQuotaExceeded, "try again after 69 minutes"), cooldown: 69 * time.Minute},
+		{name: "与账号无关的错误", err: siderError(siderCodeTooManyWords, "too many words")},
+		{name: "其他上游错误", err: siderError(500, "internal")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			p := newTokenPool([]string{"a"}, StrategyRoundRobin, cooldown, clock.now)
+			a, _ := p.Acquire("", nil)
+			p.Release(a, tt.err)
+
+			st := p.Status()[0]
+			if st.InFlight != 0 {
+				t.Errorf("归还后 in_flight = %d, want 0", st.InFlight)
+			}
+			if wantFailures := map[bool]int64{true: 0, false: 1}[tt.err == nil]; st.Failures != wantFailures {
+				t.Errorf("failures = %d, want %d", st.Failures, wantFailures)
+			}
+			if got := isAccountError(orOK(tt.err)); got != (tt.cooldown > 0) {
+				t.Errorf("isAccountError = %v, want %v", got, tt.cooldown > 0)
+			}
+
+			got, retryAt := p.Acquire("", nil)
+			if tt.cooldown == 0 {
+				if got == nil || !st.Healthy {
+					t.Fatal("不应冷却")
+				}
+				return
+			}
+			if got != nil || st.Healthy {
+				t.Fatal("冷却中不应被选中")
+			}
+			if want := clock.now().Add(tt.cooldown); !retryAt.Equal(want) {
+				t.Errorf("冷却结束时间 = %v, want %v", retryAt, want)
+			}
+
+			clock.advance(tt.cooldown - time.Second)
+			if got, _ := p.Acquire("a", nil); got != nil {
+				t.Error("冷却结束前即使是 preferred 也不应被选中")
+			}
+			clock.advance(time.Second)
+			if got, _ := p.Acquire("", nil); got == nil {
+				t.Error("冷却结束后应恢复使用")
+			}
+		})
+	}
+}
+
+// orOK 将 nil 转为成功的占位错误, 便于统一调用 isAccountError
+func orOK(apiErr *apiError) *apiError {
+	if apiErr == nil {
+		return &apiError{Status: http.StatusOK}
+	}
+	return apiErr
+}
+
+// fakeSider 按 token 返回预设的上游响应, 并记录收到请求的账号
+type fakeSider struct {
+	mu       sync.Mutex
+	replies  map[string]string // token -> 事件, 缺省为正常回复
+	accounts []string
+}
+
+func (f *fakeSider) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := strings.TrimPrefix(r.Header.Get("authorization"), "Bearer ")
+	f.mu.Lock()
+	f.accounts = append(f.accounts, token)
+	reply, ok := f.replies[token]
+	f.mu.Unlock()
+	if !ok {
+		reply = `{"code":0,"msg":"","data":{"type":"text","text":"ok"}}`
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	fmt.Fprintf(w, "data:%s\n\ndata:[DONE]\n\n", reply)
+}
+
+func TestSendWithAccounts(t *testing.T) {
+	const (
+		rateLimited  = `{"code":1101,"msg":"try again after 2 minutes"}`
+		unauthorized = `{"code":1001,"msg":"unauthorized"}`
+		tooManyWords = `{"code":603,"msg":"Too many words"}`
+	)
+	messages := []Message{
+		{Role: "user", Content: "first"},
+		{Role: "assistant", Content: "answer"},
+		{Role: "user", Content: "second"},
+	}
+	sess := siderSession{CID: "c-1", ParentMessageID: "m-1", Turns: 2, Account: "b"}
+
+	tests := []struct {
+		name      string
+		replies   map[string]string
+		cooling   []string // 请求前已在冷却中的账号
+		resumable bool
+		wantTried []string // 上游依次收到请求的账号
+		wantUsed  string   // 最终使用的账号, 为空表示失败
+		wantCode  string
+		wantCID   string
+	}{
+		{name: "第一个账号成功", wantTried: []string{"a"}, wantUsed: "a"},
+		// 重试时在未试过的账号中继续轮询
+		{name: "限流时换下一个账号", replies: map[string]string{"a": rateLimited},
+			wantTried: []string{"a", "c"}, wantUsed: "c"},
+		{name: "与账号无关的错误不重试", replies: map[string]string{"a": tooManyWords},
+			wantTried: []string{"a"}, wantCode: "context_length_exceeded"},
+		{name: "所有账号都失败时返回最后一个错误", replies: map[string]string{"a": rateLimited, "b": unauthorized, "c": unauthorized},
+			wantTried: []string{"a", "c", "b"}, wantCode: "upstream_unauthorized"},
+		{name: "所有账号都在冷却中", cooling: []string{"a", "b", "c"}, wantCode: "upstream_unavailable"},
+		{name: "复用会话时优先使用会话所属账号", resumable: true,
+			wantTried: []string{"b"}, wantUsed: "b", wantCID: "c-1"},
+		{name: "会话所属账号失败时以完整历史换账号", resumable: true, replies: map[string]string{"b": rateLimited},
+			wantTried: []string{"b", "a"}, wantUsed: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			upstream := &fakeSider{replies: tt.replies}
+			ts := httptest.NewServer(upstream)
+			defer ts.Close()
+
+			clock := newFakeClock()
+			s := newTestServer(t, Config{SiderURL: ts.URL})
+			s.tokens = newTokenPool([]string{"a", "b", "c"}, StrategyRoundRobin, time.Minute, clock.now)
+			for _, token := range tt.cooling {
+				a, _ := s.tokens.Acquire(token, nil)
+				s.tokens.Release(a, siderError(siderCodeRateLimited, "try again after 90 seconds"))
+			}
+
+			r := httptest.NewRequest("POST", "/v1/chat/completions", nil)
+			siderReq, account, resp, apiErr := s.sendWithAccounts(r, siderRequest{Model: "gpt-4o"}, messages, "k", sess, tt.resumable)
+			if resp != nil {
+				resp.Body.Close()
+			}
+
+			if !reflect.DeepEqual(upstream.accounts, tt.wantTried) {
+				t.Errorf("上游收到的账号 = %v, want %v", upstream.accounts, tt.wantTried)
+			}
+			if tt.wantUsed == "" {
+				if apiErr == nil || apiErr.Code != tt.wantCode || account != nil {
+					t.Fatalf("err = %+v, account = %v, want %s", apiErr, account, tt.wantCode)
+				}
+				if tt.wantCode == "upstream_unavailable" && apiErr.RetryAfter != 91 {
+					t.Errorf("Retry-After = %d, want 91", apiErr.RetryAfter)
+				}
+				return
+			}
+			if apiErr != nil || account == nil || account.Token != tt.wantUsed {
+				t.Fatalf("err = %+v, account = %v, want %s", apiErr, account, tt.wantUsed)
+			}
+			if siderReq.CID != tt.wantCID {
+				t.Errorf("cid = %q, want %q", siderReq.CID, tt.wantCID)
+			}
+			// 复用会话时只发送新增的消息, 否则发送完整历史
+			wantPrompt := s.prompt(messages)
+			if tt.wantCID != "" {
+				wantPrompt = s.prompt(messages[sess.Turns:])
+			}
+			if siderReq.Prompt != wantPrompt {
+				t.Errorf("prompt = %q, want %q", siderReq.Prompt, wantPrompt)
+			}
+			// 之前失败的账号 (限流/认证失败) 都已进入冷却
+			for _, token := range tt.wantTried[:len(tt.wantTried)-1] {
+				if a, _ := s.tokens.Acquire(token, nil); a != nil && a.Token == token {
+					t.Errorf("失败的账号 %s 应在冷却中", token)
+				}
+			}
+		})
+	}
+}
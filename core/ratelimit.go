@@ -0,0 +1,191 @@
+package core
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// tokenBucket 是按分钟匀速补充的令牌桶, 容量等于每分钟的限额
+type tokenBucket struct {
+	limit     float64
+	available float64
+	last      time.Time
+}
+
+func newTokenBucket(perMinute int, now time.Time) *tokenBucket {
+	if perMinute <= 0 {
+		return nil // 不限制
+	}
+	return &tokenBucket{limit: float64(perMinute), available: float64(perMinute), last: now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.available += now.Sub(b.last).Minutes() * b.limit
+	if b.available > b.limit {
+		b.available = b.limit
+	}
+	b.last = now
+}
+
+// wait 返回凑够 n 个令牌还需等待的时间, 单次请求超过容量时按容量计
+func (b *tokenBucket) wait(n float64) time.Duration {
+	if n > b.limit {
+		n = b.limit
+	}
+	if b.available >= n {
+		return 0
+	}
+	return time.Duration((n - b.available) / b.limit * float64(time.Minute))
+}
+
+func (b *tokenBucket) take(n float64) {
+	if n > b.limit {
+		n = b.limit
+	}
+	b.available -= n
+}
+
+// resetAfter 返回令牌桶补满所需的时间
+func (b *tokenBucket) resetAfter() time.Duration {
+	return time.Duration((b.limit - b.available) / b.limit * float64(time.Minute))
+}
+
+// rateLimit 是一组请求数 (RPM) 与 token 数 (TPM) 限额, nil 的桶表示不限制
+type rateLimit struct {
+	requests *tokenBucket
+	tokens   *tokenBucket
+}
+
+func newRateLimit(rpm, tpm int, now time.Time) *rateLimit {
+	return &rateLimit{requests: newTokenBucket(rpm, now), tokens: newTokenBucket(tpm, now)}
+}
+
+// rateLimitHeader 按 OpenAI 的约定生成 x-ratelimit-* 响应头, 需在持有锁时调用
+func rateLimitHeader(requests, tokens *tokenBucket) http.Header {
+	h := make(http.Header)
+	if b := requests; b != nil {
+		h.Set("x-ratelimit-limit-requests", strconv.Itoa(int(b.limit)))
+		h.Set("x-ratelimit-remaining-requests", strconv.Itoa(int(b.available)))
+		h.Set("x-ratelimit-reset-requests", formatReset(b.resetAfter()))
+	}
+	if b := tokens; b != nil {
+		h.Set("x-ratelimit-limit-tokens", strconv.Itoa(int(b.limit)))
+		h.Set("x-ratelimit-remaining-tokens", strconv.Itoa(int(b.available)))
+		h.Set("x-ratelimit-reset-tokens", formatReset(b.resetAfter()))
+	}
+	return h
+}
+
+func formatReset(d time.Duration) string {
+	return d.Round(time.Millisecond).String()
+}
+
+// RateLimiter 在请求发往上游之前按全局与每个客户端 Key 限流
+type RateLimiter struct {
+	mu     sync.Mutex
+	global *rateLimit
+	keys   map[string]*rateLimit
+	keyRPM int // Key 未单独配置时的默认限额
+	keyTPM int
+	now    func() time.Time // 时钟, 测试时替换
+}
+
+func NewRateLimiter(cfg Config) *RateLimiter {
+	return newRateLimiter(cfg, time.Now)
+}
+
+func newRateLimiter(cfg Config, now func() time.Time) *RateLimiter {
+	return &RateLimiter{
+		global: newRateLimit(cfg.RateLimitRPM, cfg.RateLimitTPM, now()),
+		keys:   make(map[string]*rateLimit),
+		keyRPM: cfg.KeyRateLimitRPM,
+		keyTPM: cfg.KeyRateLimitTPM,
+		now:    now,
+	}
+}
+
+// Allow 检查并扣除一次请求与 tokens 个估算 token, 返回应写入响应头的限额信息
+// (有 Key 级限额时优先展示 Key 级). 被拒绝时返回带同样响应头的 429 错误.
+func (l *RateLimiter) Allow(key *APIKey, tokens int) (http.Header, *apiError) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	limits := []*rateLimit{l.global}
+	if key != nil {
+		kl, ok := l.keys[key.Key]
+		if !ok {
+			rpm, tpm := l.keyRPM, l.keyTPM
+			if key.RPM > 0 {
+				rpm = key.RPM
+			}
+			if key.TPM > 0 {
+				tpm = key.TPM
+			}
+			kl = newRateLimit(rpm, tpm, now)
+			l.keys[key.Key] = kl
+		}
+		limits = append(limits, kl)
+	}
+
+	// 先检查所有桶, 全部满足才扣除, 避免被拒绝的请求消耗额度
+	var requests, tokenLimit *tokenBucket
+	for _, rl := range limits {
+		for _, b := range []*tokenBucket{rl.requests, rl.tokens} {
+			if b != nil {
+				b.refill(now)
+			}
+		}
+		if rl.requests != nil {
+			requests = rl.requests
+		}
+		if rl.tokens != nil {
+			tokenLimit = rl.tokens
+		}
+	}
+	for _, rl := range limits {
+		var wait time.Duration
+		var what string
+		if rl.requests != nil && rl.requests.wait(1) > 0 {
+			wait, what = rl.requests.wait(1), "requests"
+			requests = rl.requests
+		} else if rl.tokens != nil && rl.tokens.wait(float64(tokens)) > 0 {
+			wait, what = rl.tokens.wait(float64(tokens)), "tokens"
+			tokenLimit = rl.tokens
+		}
+		if wait > 0 {
+			scope := "全局"
+			if rl != l.global {
+				scope = fmt.Sprintf("API key '%s' 的", key.Name)
+			}
+			return nil, &apiError{
+				Status:     http.StatusTooManyRequests,
+				Type:       "rate_limit_error",
+				Code:       "rate_limit_exceeded",
+				Message:    fmt.Sprintf("超出%s限流 (%s per minute), 请在 %s 后重试", scope, what, formatReset(wait)),
+				RetryAfter: int(wait/time.Second) + 1,
+				Header:     rateLimitHeader(requests, tokenLimit),
+			}
+		}
+	}
+
+	for _, rl := range limits {
+		if rl.requests != nil {
+			rl.requests.take(1)
+		}
+		if rl.tokens != nil {
+			rl.tokens.take(float64(tokens))
+		}
+	}
+	return rateLimitHeader(requests, tokenLimit), nil
+}
+
+// Forget 丢弃 Key 的令牌桶, Key 的限额被修改或 Key 被删除后调用
+func (l *RateLimiter) Forget(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+}
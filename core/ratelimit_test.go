@@ -0,0 +1,174 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+// fakeClock 是可手动拨动的时钟
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time          { return c.t }
+func (c *fakeClock) advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{t: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestTokenBucket(t *testing.T) {
+	clock := newFakeClock()
+	if b := newTokenBucket(0, clock.now()); b != nil {
+		t.Fatal("限额为 0 时应不限制")
+	}
+
+	b := newTokenBucket(60, clock.now())
+	b.take(60)
+	if got := b.wait(1); got != time.Second {
+		t.Errorf("耗尽后 wait(1) = %v, want 1s", got)
+	}
+	if got := b.resetAfter(); got != time.Minute {
+		t.Errorf("耗尽后 resetAfter = %v, want 1m", got)
+	}
+
+	clock.advance(500 * time.Millisecond)
+	b.refill(clock.now())
+	if got := b.wait(1); got != 500*time.Millisecond {
+		t.Errorf("半秒后 wait(1) = %v, want 500ms", got)
+	}
+
+	clock.advance(500 * time.Millisecond)
+	b.refill(clock.now())
+	if got := b.wait(1); got != 0 {
+		t.Errorf("一秒后 wait(1) = %v, want 0", got)
+	}
+
+	clock.advance(time.Hour)
+	b.refill(clock.now())
+	if b.available != 60 {
+		t.Errorf("补充后 available = %v, 不应超过容量 60", b.available)
+	}
+
+	// 单次请求超过容量时按容量计, 桶满即可通过
+	if got := b.wait(1000); got != 0 {
+		t.Errorf("桶满时 wait(1000) = %v, want 0", got)
+	}
+	b.take(1000)
+	if b.available != 0 {
+		t.Errorf("take(1000) 后 available = %v, want 0", b.available)
+	}
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	type step struct {
+		advance time.Duration
+		key     *APIKey
+		tokens  int
+		status  int    // 0 表示应放行
+		header  string // 放行时 x-ratelimit-remaining-requests 的期望值
+	}
+	alice := &APIKey{Key: "sk-alice", Name: "alice"}
+	limited := &APIKey{Key: "sk-limited", Name: "limited", RPM: 1}
+	tests := []struct {
+		name  string
+		cfg   Config
+		steps []step
+	}{
+		{
+			name: "不限制",
+			steps: []step{
+				{tokens: 1 << 20},
+				{key: alice, tokens: 1 << 20},
+			},
+		},
+		{
+			name: "全局 RPM 耗尽后按时间补充",
+			cfg:  Config{RateLimitRPM: 2},
+			steps: []step{
+				{header: "1"},
+				{header: "0"},
+				{status: http.StatusTooManyRequests},
+				{advance: 29 * time.Second, status: http.StatusTooManyRequests},
+				{advance: time.Second, header: "0"},
+			},
+		},
+		{
+			name: "TPM 不足时拒绝且不扣除请求数",
+			cfg:  Config{RateLimitRPM: 10, RateLimitTPM: 100},
+			steps: []step{
+				{tokens: 80, header: "9"},
+				{tokens: 30, status: http.StatusTooManyRequests},
+				{tokens: 20, header: "8"},
+			},
+		},
+		{
+			name: "Key 级限额互不影响",
+			cfg:  Config{KeyRateLimitRPM: 1},
+			steps: []step{
+				{key: alice, header: "0"},
+				{key: alice, status: http.StatusTooManyRequests},
+				{key: &APIKey{Key: "sk-bob", Name: "bob"}, header: "0"},
+				{advance: time.Minute, key: alice, header: "0"},
+			},
+		},
+		{
+			name: "Key 单独配置的限额优先",
+			cfg:  Config{KeyRateLimitRPM: 100},
+			steps: []step{
+				{key: limited, header: "0"},
+				{key: limited, status: http.StatusTooManyRequests},
+			},
+		},
+		{
+			name: "Key 被拒绝时不消耗全局额度",
+			cfg:  Config{RateLimitRPM: 2, KeyRateLimitRPM: 1},
+			steps: []step{
+				{key: alice, header: "0"},
+				{key: alice, status: http.StatusTooManyRequests},
+				{header: "0"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clock := newFakeClock()
+			l := newRateLimiter(tt.cfg, clock.now)
+			for i, s := range tt.steps {
+				clock.advance(s.advance)
+				h, apiErr := l.Allow(s.key, s.tokens)
+				if s.status != 0 {
+					if apiErr == nil || apiErr.Status != s.status {
+						t.Fatalf("第 %d 步: err = %+v, want status %d", i, apiErr, s.status)
+					}
+					if apiErr.RetryAfter < 1 || apiErr.Header.Get("x-ratelimit-limit-requests") == "" && apiErr.Header.Get("x-ratelimit-limit-tokens") == "" {
+						t.Errorf("第 %d 步: 429 应带 Retry-After 与 x-ratelimit-* 响应头, got %+v", i, apiErr)
+					}
+					continue
+				}
+				if apiErr != nil {
+					t.Fatalf("第 %d 步: err = %+v, want 放行", i, apiErr)
+				}
+				if got := h.Get("x-ratelimit-remaining-requests"); got != s.header {
+					t.Errorf("第 %d 步: remaining-requests = %q, want %q", i, got, s.header)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterForget(t *testing.T) {
+	clock := newFakeClock()
+	l := newRateLimiter(Config{KeyRateLimitRPM: 1}, clock.now)
+	key := &APIKey{Key: "sk-a", Name: "a"}
+	if _, apiErr := l.Allow(key, 0); apiErr != nil {
+		t.Fatal(apiErr)
+	}
+	if _, apiErr := l.Allow(key, 0); apiErr == nil {
+		t.Fatal("第二次请求应被限流")
+	}
+	key.RPM = 2
+	l.Forget(key.Key)
+	if _, apiErr := l.Allow(key, 0); apiErr != nil {
+		t.Errorf("Forget 后应按新限额重新计数, err = %+v", apiErr)
+	}
+}
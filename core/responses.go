@@ -0,0 +1,493 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenAI Responses API 请求结构
+type ResponsesRequest struct {
+	Model              string          `json:"model"`
+	Input              json.RawMessage `json:"input"` // 字符串或输入项数组
+	Instructions       string          `json:"instructions"`
+	PreviousResponseID string          `json:"previous_response_id"`
+	Stream             bool            `json:"stream"`
+	Store              *bool           `json:"store"`
+	MaxOutputTokens    int             `json:"max_output_tokens"`
+	Reasoning          *struct {
+		Effort string `json:"effort"`
+	} `json:"reasoning"`
+}
+
+// Responses API 输入项, 只保留转换需要的字段
+type ResponsesInputItem struct {
+	Type    string          `json:"type"`
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// Responses API 响应对象
+type ResponseObject struct {
+	ID                 string          `json:"id"`
+	Object             string          `json:"object"`
+	CreatedAt          int64           `json:"created_at"`
+	Status             string          `json:"status"`
+	Model              string          `json:"model"`
+	Instructions       *string         `json:"instructions"`
+	PreviousResponseID *string         `json:"previous_response_id"`
+	Output             []interface{}   `json:"output"` // ResponseReasoningItem 与 ResponseOutputItem
+	Usage              *ResponsesUsage `json:"usage"`
+	Error              interface{}     `json:"error"`
+}
+
+type ResponseOutputItem struct {
+	Type    string                `json:"type"`
+	ID      string                `json:"id"`
+	Status  string                `json:"status"`
+	Role    string                `json:"role"`
+	Content []ResponseContentPart `json:"content"`
+}
+
+// reasoning 输出项, 思考过程以 summary 的形式给出
+type ResponseReasoningItem struct {
+	Type    string                `json:"type"`
+	ID      string                `json:"id"`
+	Summary []ResponseSummaryPart `json:"summary"`
+}
+
+type ResponseSummaryPart struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+type ResponseContentPart struct {
+	Type        string        `json:"type"`
+	Text        string        `json:"text"`
+	Annotations []interface{} `json:"annotations"`
+	ImageURL    string        `json:"image_url,omitempty"` // 仅 input_image
+}
+
+type ResponsesUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+	TotalTokens  int `json:"total_tokens"`
+}
+
+// storedResponse 是为 previous_response_id 保存的对话状态
+type storedResponse struct {
+	Response ResponseObject
+	Messages []Message // 不含 instructions 的完整对话, 最后一条为本次回复
+	Owner    string    // 创建该 response 的客户端 Key, 其他 Key 无法读取或沿用
+	LastUsed time.Time
+}
+
+// 最多保存的 response 数, 超出时淘汰最久未使用的
+const maxStoredResponses = 10000
+
+// responseStore 保存已完成的 response, 闲置超过 ttl 后定期清理
+type responseStore struct {
+	mu        sync.Mutex
+	responses map[string]*storedResponse
+	ttl       time.Duration
+}
+
+func newResponseStore(ttl time.Duration) *responseStore {
+	st := &responseStore{responses: make(map[string]*storedResponse), ttl: ttl}
+	if ttl > 0 {
+		go st.cleanupLoop()
+	}
+	return st
+}
+
+// Get 返回 owner 创建的 response, 不存在、已过期或属于其他 Key 时返回 false
+func (st *responseStore) Get(id, owner string) (storedResponse, bool) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	stored, ok := st.responses[id]
+	if !ok || stored.Owner != owner {
+		return storedResponse{}, false
+	}
+	if st.ttl > 0 && time.Since(stored.LastUsed) > st.ttl {
+		delete(st.responses, id)
+		return storedResponse{}, false
+	}
+	stored.LastUsed = time.Now()
+	return *stored, true
+}
+
+func (st *responseStore) Save(stored storedResponse) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, exists := st.responses[stored.Response.ID]; !exists && len(st.responses) >= maxStoredResponses {
+		oldest := ""
+		for id, old := range st.responses {
+			if oldest == "" || old.LastUsed.Before(st.responses[oldest].LastUsed) {
+				oldest = id
+			}
+		}
+		delete(st.responses, oldest)
+	}
+	stored.LastUsed = time.Now()
+	st.responses[stored.Response.ID] = &stored
+}
+
+func (st *responseStore) cleanupLoop() {
+	ticker := time.NewTicker(st.ttl / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		st.cleanup()
+	}
+}
+
+// 清理闲置超过 ttl 的 response
+func (st *responseStore) cleanup() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	for id, stored := range st.responses {
+		if time.Since(stored.LastUsed) > st.ttl {
+			delete(st.responses, id)
+		}
+	}
+}
+
+// responseOwner 返回请求方的 Key, 未启用认证时为空
+func responseOwner(r *http.Request) string {
+	if key := identityFrom(r); key != nil {
+		return key.Key
+	}
+	return ""
+}
+
+// responsesText 将字符串或内容块数组形式的 content 展开为纯文本
+func responsesText(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+
+	var parts []ResponseContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return ""
+	}
+	texts := make([]string, 0, len(parts))
+	for _, p := range parts {
+		switch p.Type {
+		case "input_text", "output_text", "text":
+			texts = append(texts, p.Text)
+		}
+	}
+	return strings.Join(texts, "\n")
+}
+
+// responsesImages 返回内容块数组中 input_image 的图片地址
+func responsesImages(raw json.RawMessage) []string {
+	var parts []ResponseContentPart
+	if err := json.Unmarshal(raw, &parts); err != nil {
+		return nil
+	}
+	var images []string
+	for _, p := range parts {
+		if p.Type == "input_image" && p.ImageURL != "" {
+			images = append(images, p.ImageURL)
+		}
+	}
+	return images
+}
+
+// inputMessages 将 input 字段转换为消息列表
+func (req *ResponsesRequest) inputMessages() ([]Message, error) {
+	if len(req.Input) == 0 {
+		return nil, nil
+	}
+	var text string
+	if err := json.Unmarshal(req.Input, &text); err == nil {
+		return []Message{{Role: "user", Content: text}}, nil
+	}
+
+	var items []ResponsesInputItem
+	if err := json.Unmarshal(req.Input, &items); err != nil {
+		return nil, err
+	}
+	messages := make([]Message, 0, len(items))
+	for _, item := range items {
+		if item.Type != "" && item.Type != "message" {
+			continue
+		}
+		role := item.Role
+		if role == "" {
+			role = "user"
+		}
+		messages = append(messages, Message{Role: role, Content: responsesText(item.Content), Images: responsesImages(item.Content)})
+	}
+	return messages, nil
+}
+
+func newResponseObject(id, model string, req *ResponsesRequest) ResponseObject {
+	resp := ResponseObject{
+		ID:        id,
+		Object:    "response",
+		CreatedAt: time.Now().Unix(),
+		Status:    "in_progress",
+		Model:     model,
+		Output:    []interface{}{},
+	}
+	if req.Instructions != "" {
+		resp.Instructions = &req.Instructions
+	}
+	if req.PreviousResponseID != "" {
+		resp.PreviousResponseID = &req.PreviousResponseID
+	}
+	return resp
+}
+
+func newReasoningItem(id, text string) ResponseReasoningItem {
+	return ResponseReasoningItem{
+		Type:    "reasoning",
+		ID:      id,
+		Summary: []ResponseSummaryPart{{Type: "summary_text", Text: text}},
+	}
+}
+
+func newOutputMessage(id, status, text string) ResponseOutputItem {
+	return ResponseOutputItem{
+		Type:    "message",
+		ID:      id,
+		Status:  status,
+		Role:    "assistant",
+		Content: []ResponseContentPart{{Type: "output_text", Text: text, Annotations: []interface{}{}}},
+	}
+}
+
+// responsesHandler 处理 POST /v1/responses (非流式 + 流式)
+func (s *Server) responsesHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "读取请求失败", "")
+		return
+	}
+	defer r.Body.Close()
+
+	var req ResponsesRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		fmt.Printf("解析请求体失败: %v\n", err)
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "解析请求失败", "")
+		return
+	}
+	input, err := req.inputMessages()
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "input 格式错误: "+err.Error(), "")
+		return
+	}
+	if len(input) == 0 {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "Missing required parameter: 'input'.", "")
+		return
+	}
+
+	// 拼接 previous_response_id 对应的历史对话, instructions 不会沿用到下一轮
+	var history []Message
+	if req.PreviousResponseID != "" {
+		prev, ok := s.responses.Get(req.PreviousResponseID, responseOwner(r))
+		if !ok {
+			writeOpenAIError(w, http.StatusNotFound, "invalid_request_error",
+				fmt.Sprintf("Previous response with id '%s' not found.", req.PreviousResponseID), "previous_response_not_found")
+			return
+		}
+		history = prev.Messages
+	}
+	conversation := append(append([]Message{}, history...), input...)
+
+	userReq := &UserRequest{Model: req.Model, Stream: req.Stream, MaxTokens: req.MaxOutputTokens}
+	if req.Reasoning != nil {
+		userReq.ReasoningEffort = req.Reasoning.Effort
+	}
+	if req.Instructions != "" {
+		userReq.Messages = append(userReq.Messages, Message{Role: "system", Content: req.Instructions})
+	}
+	userReq.Messages = append(userReq.Messages, conversation...)
+
+	c, apiErr := s.startCompletion(r, userReq)
+	if apiErr != nil {
+		writeAPIError(w, apiErr)
+		return
+	}
+	defer c.finish()
+
+	// response id 可用于读取与沿用对话, 使用随机值避免被猜到
+	respID := "resp_" + randomHex(24)
+	itemID := "msg_" + randomHex(24)
+	reasoningID := "rs_" + randomHex(24)
+	resp := newResponseObject(respID, c.Model, &req)
+	c.setHeaders(w)
+
+	// 完成后填充输出与用量, 并保存对话状态供 previous_response_id 使用
+	complete := func() ResponseObject {
+		text := c.Text()
+		resp.Status = "completed"
+		resp.Output = []interface{}{}
+		if reasoning := c.Reasoning(); reasoning != "" {
+			resp.Output = append(resp.Output, newReasoningItem(reasoningID, reasoning))
+		}
+		resp.Output = append(resp.Output, newOutputMessage(itemID, "completed", text))
+		input, output := c.PromptTokens(), c.CompletionTokens()
+		resp.Usage = &ResponsesUsage{InputTokens: input, OutputTokens: output, TotalTokens: input + output}
+
+		if req.Store == nil || *req.Store {
+			s.responses.Save(storedResponse{
+				Response: resp,
+				Messages: append(conversation, Message{Role: "assistant", Content: text}),
+				Owner:    responseOwner(r),
+			})
+		}
+		return resp
+	}
+
+	if !req.Stream || !c.Stream {
+		if err := c.each(func(SiderEvent) bool { return true }); err != nil {
+			fmt.Printf("读取响应失败: %v\n", err)
+			writeAPIError(w, toAPIError(err))
+			return
+		}
+		w.Header().Set("X-Conversation-ID", c.ConversationID())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(complete())
+		return
+	}
+
+	// 流式: 按 Responses API 的类型化事件输出
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	seq := 0
+	sendEvent := func(event string, data map[string]interface{}) bool {
+		data["type"] = event
+		data["sequence_number"] = seq
+		seq++
+		payload, err := json.Marshal(data)
+		if err != nil {
+			fmt.Printf("转换Responses格式失败: %v\n", err)
+			return true
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			fmt.Printf("写入响应失败: %v\n", err)
+			return false
+		}
+		flush(w)
+		return true
+	}
+
+	sendEvent("response.created", map[string]interface{}{"response": resp})
+	sendEvent("response.in_progress", map[string]interface{}{"response": resp})
+
+	// 思考过程作为 reasoning 输出项在前, 正文 message 输出项在后, 都在收到第一段内容时才开启
+	outputIndex, current := -1, ""
+	startReasoning := func() bool {
+		outputIndex++
+		current = "reasoning"
+		sendEvent("response.output_item.added", map[string]interface{}{
+			"output_index": outputIndex,
+			"item":         ResponseReasoningItem{Type: "reasoning", ID: reasoningID, Summary: []ResponseSummaryPart{}},
+		})
+		return sendEvent("response.reasoning_summary_part.added", map[string]interface{}{
+			"item_id": reasoningID, "output_index": outputIndex, "summary_index": 0,
+			"part": ResponseSummaryPart{Type: "summary_text", Text: ""},
+		})
+	}
+	startMessage := func() bool {
+		if current == "reasoning" {
+			item := newReasoningItem(reasoningID, c.Reasoning())
+			sendEvent("response.reasoning_summary_text.done", map[string]interface{}{
+				"item_id": reasoningID, "output_index": outputIndex, "summary_index": 0, "text": c.Reasoning(),
+			})
+			sendEvent("response.reasoning_summary_part.done", map[string]interface{}{
+				"item_id": reasoningID, "output_index": outputIndex, "summary_index": 0, "part": item.Summary[0],
+			})
+			sendEvent("response.output_item.done", map[string]interface{}{"output_index": outputIndex, "item": item})
+		}
+		outputIndex++
+		current = "message"
+		sendEvent("response.output_item.added", map[string]interface{}{
+			"output_index": outputIndex,
+			"item":         ResponseOutputItem{Type: "message", ID: itemID, Status: "in_progress", Role: "assistant", Content: []ResponseContentPart{}},
+		})
+		return sendEvent("response.content_part.added", map[string]interface{}{
+			"item_id": itemID, "output_index": outputIndex, "content_index": 0,
+			"part": ResponseContentPart{Type: "output_text", Text: "", Annotations: []interface{}{}},
+		})
+	}
+
+	err = c.each(func(ev SiderEvent) bool {
+		if ev.Type == EventPulse {
+			return writeKeepAlive(w)
+		}
+		if reasoning := ev.Reasoning(); reasoning != "" && current != "message" {
+			if current == "" && !startReasoning() {
+				return false
+			}
+			return sendEvent("response.reasoning_summary_text.delta", map[string]interface{}{
+				"item_id": reasoningID, "output_index": outputIndex, "summary_index": 0, "delta": reasoning,
+			})
+		}
+		text := ev.Content()
+		if text == "" {
+			return true
+		}
+		if current != "message" && !startMessage() {
+			return false
+		}
+		return sendEvent("response.output_text.delta", map[string]interface{}{
+			"item_id": itemID, "output_index": outputIndex, "content_index": 0, "delta": text,
+		})
+	})
+	if err != nil {
+		fmt.Printf("读取响应失败: %v\n", err)
+		resp.Status = "failed"
+		apiErr := toAPIError(err)
+		resp.Error = map[string]string{"code": apiErr.Code, "message": apiErr.Message}
+		sendEvent("response.failed", map[string]interface{}{"response": resp})
+		return
+	}
+	if current != "message" {
+		startMessage()
+	}
+
+	final := complete()
+	item := final.Output[len(final.Output)-1].(ResponseOutputItem)
+	sendEvent("response.output_text.done", map[string]interface{}{
+		"item_id": itemID, "output_index": outputIndex, "content_index": 0, "text": c.Text(),
+	})
+	sendEvent("response.content_part.done", map[string]interface{}{
+		"item_id": itemID, "output_index": outputIndex, "content_index": 0, "part": item.Content[0],
+	})
+	sendEvent("response.output_item.done", map[string]interface{}{"output_index": outputIndex, "item": item})
+	sendEvent("response.completed", map[string]interface{}{"response": final})
+}
+
+// getResponseHandler 处理 GET /v1/responses/{id}
+func (s *Server) getResponseHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "Method not allowed", "")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, s.cfg.RoutePrefix+"/v1/responses/")
+	stored, ok := s.responses.Get(id, responseOwner(r))
+	if !ok {
+		writeOpenAIError(w, http.StatusNotFound, "invalid_request_error",
+			fmt.Sprintf("Response with id '%s' not found.", id), "not_found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stored.Response)
+}
@@ -0,0 +1,314 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Server 是所有部署目标共用的服务核心
+type Server struct {
+	cfg        Config
+	client     *http.Client
+	mux        *http.ServeMux
+	sessions   *SessionManager
+	responses  *responseStore // Responses API 的 previous_response_id 状态
+	tokens     *TokenPool
+	keys       *KeyStore
+	limiter    *RateLimiter
+	models     *ModelRegistry
+	stats      *requestStats
+	tokenizers *Tokenizers
+}
+
+// NewServer 根据配置创建服务并注册路由
+func NewServer(cfg Config) (*Server, error) {
+	client, err := createHTTPClient(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("创建HTTP客户端失败: %v", err)
+	}
+
+	keys, err := NewKeyStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	models, err := NewModelRegistry(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Server{
+		cfg:        cfg,
+		client:     client,
+		mux:        http.NewServeMux(),
+		sessions:   NewSessionManager(cfg.SessionTTL),
+		responses:  newResponseStore(cfg.SessionTTL),
+		tokens:     NewTokenPool(cfg.SiderTokens, cfg.TokenStrategy, cfg.TokenCooldown),
+		keys:       keys,
+		limiter:    NewRateLimiter(cfg),
+		models:     models,
+		stats:      newRequestStats(),
+		tokenizers: NewTokenizers(cfg),
+	}
+
+	if err := s.tokens.LoadFile(cfg.TokensFile); err != nil {
+		return nil, err
+	}
+
+	// 检查 token 有效期: 配置了 token 但全部过期时拒绝启动
+	if s.tokens.Len() > 0 && s.tokens.CheckExpiry() == 0 {
+		return nil, fmt.Errorf("所有 Sider Token 均已过期, 请更换 SIDER_AUTH_TOKEN")
+	}
+	if cfg.TokenCheck > 0 {
+		go s.tokens.watchExpiry(cfg.TokenCheck)
+	}
+	if cfg.KeysFile != "" {
+		go s.keys.persistUsage(cfg.KeysFile, keyUsageFlushInterval)
+	}
+
+	s.routes()
+	return s, nil
+}
+
+func (s *Server) routes() {
+	p := s.cfg.RoutePrefix
+
+	s.mux.HandleFunc("/", s.indexHandler) // 添加主页路由
+	s.mux.HandleFunc(p+"/v1/chat/completions", s.withCORS("POST, OPTIONS", s.authMiddleware(s.completionsHandler)))
+	s.mux.HandleFunc(p+"/status", s.withCORS("GET, OPTIONS", s.authMiddleware(s.statusHandler)))
+	s.mux.HandleFunc(p+"/v1/models", s.withCORS("GET, OPTIONS", s.authMiddleware(s.listModelsHandler)))
+	s.mux.HandleFunc(p+"/v1/images/generations", s.withCORS("POST, OPTIONS", s.authMiddleware(s.imagesHandler)))
+	s.mux.HandleFunc(p+"/v1/tokenize", s.withCORS("POST, OPTIONS", s.authMiddleware(s.tokenizeHandler)))
+	s.mux.HandleFunc(p+"/v1/count_tokens", s.withCORS("POST, OPTIONS", s.authMiddleware(s.countTokensHandler)))
+	s.mux.HandleFunc(p+"/v1/responses", s.withCORS("POST, OPTIONS", s.authMiddleware(s.responsesHandler)))
+	s.mux.HandleFunc(p+"/v1/responses/", s.withCORS("GET, OPTIONS", s.authMiddleware(s.getResponseHandler)))
+	s.mux.HandleFunc(p+"/v1/messages", s.withCORS("POST, OPTIONS", s.authMiddleware(s.anthropicMessagesHandler)))
+	s.mux.HandleFunc(p+"/v1beta/models/", s.withCORS("POST, OPTIONS", s.authMiddleware(s.geminiHandler)))
+	s.mux.HandleFunc(p+"/admin", s.adminPageHandler)
+	s.mux.HandleFunc(p+"/api/admin/", s.withCORS("GET, POST, PUT, DELETE, OPTIONS", s.adminAuth(s.adminHandler)))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// Run 加载完配置后启动服务, 供各部署目标的 main 函数调用
+func Run(cfg Config) {
+	s, err := NewServer(cfg)
+	if err != nil {
+		fmt.Printf("服务器启动失败: %v\n", err)
+		return
+	}
+
+	fmt.Printf("服务器启动在 http://%s%s (profile: %s)\n", cfg.ListenAddr(), cfg.RoutePrefix, cfg.Profile)
+	fmt.Printf("支持的模型: %s\n", strings.Join(s.models.IDs(), ", "))
+	fmt.Printf("上游账号: %d 个 (策略: %s)\n", s.tokens.Len(), cfg.TokenStrategy)
+	if cfg.ProxyAddr != "" {
+		fmt.Printf("使用SOCKS5代理: %s:%s\n", cfg.ProxyAddr, cfg.ProxyPort)
+	}
+	if s.keys.Len() == 0 && !cfg.RequireAuth {
+		fmt.Println("未配置 AUTH_TOKEN / API_KEYS / KEYS_FILE, 接口无需认证即可访问")
+	} else {
+		fmt.Printf("客户端 Key: %d 个\n", s.keys.Len())
+	}
+	if cfg.RateLimitRPM > 0 || cfg.RateLimitTPM > 0 || cfg.KeyRateLimitRPM > 0 || cfg.KeyRateLimitTPM > 0 {
+		fmt.Printf("限流: 全局 %d RPM / %d TPM, 每个 Key %d RPM / %d TPM (0 为不限)\n",
+			cfg.RateLimitRPM, cfg.RateLimitTPM, cfg.KeyRateLimitRPM, cfg.KeyRateLimitTPM)
+	}
+
+	// 收到 SIGINT / SIGTERM 时停止接受新请求, 等待进行中的请求结束后退出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	srv := &http.Server{Addr: cfg.ListenAddr(), Handler: s}
+	go func() {
+		<-ctx.Done()
+		fmt.Println("正在关闭服务...")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Printf("服务器启动失败: %v\n", err)
+	}
+	s.Close()
+}
+
+// Close 在退出前写入尚未保存的状态
+func (s *Server) Close() {
+	if s.cfg.KeysFile != "" {
+		if err := s.keys.FlushUsage(s.cfg.KeysFile); err != nil {
+			fmt.Printf("保存 Key 已用次数失败: %v\n", err)
+		}
+	}
+}
+
+// withCORS 设置CORS头并直接响应 OPTIONS 预检请求
+func (s *Server) withCORS(methods string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		setCORSHeaders(w, methods)
+		if r.Method == "OPTIONS" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// authMiddleware 认证中间件
+func (s *Server) authMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.keys.Len() == 0 {
+			if s.cfg.RequireAuth {
+				http.Error(w, "Authentication token not configured", http.StatusUnauthorized)
+				return
+			}
+			next(w, r)
+			return
+		}
+
+		if clientToken(r) == "" && r.Header.Get("Authorization") == "" {
+			http.Error(w, "Authorization header is required", http.StatusUnauthorized)
+			return
+		}
+
+		key, ok := s.keys.Lookup(clientToken(r))
+		switch {
+		case !ok:
+			http.Error(w, "Invalid authorization token", http.StatusUnauthorized)
+			return
+		case !key.IsEnabled():
+			http.Error(w, "API key is disabled", http.StatusForbidden)
+			return
+		case key.Expired():
+			http.Error(w, "API key has expired", http.StatusUnauthorized)
+			return
+		}
+
+		next(w, withIdentity(r, &key))
+	}
+}
+
+// clientToken 返回客户端携带的 API Key.
+// OpenAI 客户端使用 Authorization: Bearer, Anthropic 客户端使用 x-api-key,
+// Google 客户端使用 x-goog-api-key 或 ?key= 查询参数.
+func clientToken(r *http.Request) string {
+	if authHeader := r.Header.Get("Authorization"); authHeader != "" {
+		parts := strings.Split(authHeader, " ")
+		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+			return ""
+		}
+		return parts[1]
+	}
+	if key := r.Header.Get("x-api-key"); key != "" {
+		return key
+	}
+	if key := r.Header.Get("x-goog-api-key"); key != "" {
+		return key
+	}
+	return r.URL.Query().Get("key")
+}
+
+func (s *Server) indexHandler(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != s.cfg.RoutePrefix+"/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprintf(w, "🚀服务已启动！")
+}
+
+func (s *Server) completionsHandler(w http.ResponseWriter, r *http.Request) {
+	// 读取请求体
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		fmt.Printf("读取请求体失败: %v\n", err)
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "读取请求失败", "")
+		return
+	}
+	defer r.Body.Close()
+
+	// 解析用户请求
+	var userReq UserRequest
+	if err := json.Unmarshal(body, &userReq); err != nil {
+		fmt.Printf("解析请求体失败: %v\n", err)
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "解析请求失败", "")
+		return
+	}
+
+	s.forwardToSider(w, r, &userReq)
+}
+
+func (s *Server) listModelsHandler(w http.ResponseWriter, r *http.Request) {
+	key := identityFrom(r)
+	registered := s.models.List()
+	models := make([]Model, 0, len(registered))
+	for _, m := range registered {
+		if key != nil && !key.AllowsModel(m.ID) {
+			continue
+		}
+		models = append(models, Model{
+			ID:            m.ID,
+			Object:        "model",
+			OwnedBy:       m.OwnedBy,
+			Permission:    []string{"read"},
+			Capabilities:  m.Capabilities,
+			ContextLength: m.ContextLength,
+			Aliases:       m.Aliases,
+		})
+	}
+
+	response := ModelListResponse{
+		Object: "list",
+		Data:   models,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// 服务状态响应
+type StatusResponse struct {
+	Status   string          `json:"status"` // ok / degraded / unhealthy
+	Accounts []AccountStatus `json:"accounts"`
+	Sessions int             `json:"sessions"`
+}
+
+// statusHandler 返回上游账号的健康状况与 token 有效期, 没有可用账号时返回 503
+func (s *Server) statusHandler(w http.ResponseWriter, r *http.Request) {
+	accounts := s.tokens.Status()
+	resp := StatusResponse{Status: healthOf(accounts), Accounts: accounts, Sessions: s.sessions.Len()}
+	status := http.StatusOK
+	if resp.Status == "unhealthy" {
+		status = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// healthOf 根据账号状态返回整体健康状况: 全部可用为 ok, 部分可用为 degraded, 没有可用账号 (包括尚未配置账号) 为 unhealthy.
+// 未配置账号时所有对话请求都会失败, 探活应能发现
+func healthOf(accounts []AccountStatus) string {
+	healthy := 0
+	for _, a := range accounts {
+		if a.Healthy {
+			healthy++
+		}
+	}
+	switch {
+	case healthy == 0:
+		return "unhealthy"
+	case healthy < len(accounts):
+		return "degraded"
+	}
+	return "ok"
+}
@@ -0,0 +1,23 @@
+package core
+
+import "testing"
+
+func TestHealthOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		accounts []AccountStatus
+		want     string
+	}{
+		{name: "未配置账号", want: "unhealthy"},
+		{name: "全部可用", accounts: []AccountStatus{{Healthy: true}, {Healthy: true}}, want: "ok"},
+		{name: "部分可用", accounts: []AccountStatus{{Healthy: true}, {}}, want: "degraded"},
+		{name: "全部不可用", accounts: []AccountStatus{{}, {}}, want: "unhealthy"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := healthOf(tt.accounts); got != tt.want {
+				t.Errorf("healthOf = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package core
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// siderSession 记录一个客户端对话在 Sider 侧对应的会话
+type siderSession struct {
+	CID             string
+	ParentMessageID string // 下一轮使用上一次 assistant 消息作为 parent
+	Turns           int    // 上游已知的消息条数 (含最近一次 assistant 回复)
+	PrefixHash      string // 这些消息 (按客户端发回的形式) 的摘要, 用于确认客户端发回的历史未被改写
+	Account         string // 创建该会话的上游账号 token, 上游会话只能由同一账号继续
+	CreatedAt       time.Time
+	LastUsed        time.Time
+}
+
+// continues 判断 messages 是否恰好是该会话的下一轮: 前缀与上游一致, 且之后有新消息
+func (sess *siderSession) continues(messages []Message) bool {
+	if sess.CID == "" || sess.Turns == 0 || len(messages) <= sess.Turns {
+		return false
+	}
+	return messages[sess.Turns-1].Role == "assistant" && hashMessages(messages[:sess.Turns]) == sess.PrefixHash
+}
+
+// SessionManager 管理 Sider 会话的复用与过期
+type SessionManager struct {
+	mu       sync.Mutex
+	sessions map[string]*siderSession
+	ttl      time.Duration
+}
+
+// NewSessionManager 创建会话管理器, 闲置超过 ttl 的会话会被定期清理
+func NewSessionManager(ttl time.Duration) *SessionManager {
+	m := &SessionManager{
+		sessions: make(map[string]*siderSession),
+		ttl:      ttl,
+	}
+	if ttl > 0 {
+		go m.cleanupLoop()
+	}
+	return m
+}
+
+// Get 返回会话的副本, 不存在或已过期时返回 false
+func (m *SessionManager) Get(key string) (siderSession, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sess, ok := m.sessions[key]
+	if !ok {
+		return siderSession{}, false
+	}
+	if m.ttl > 0 && time.Since(sess.LastUsed) > m.ttl {
+		delete(m.sessions, key)
+		return siderSession{}, false
+	}
+	return *sess, true
+}
+
+// Save 保存或更新会话
+func (m *SessionManager) Save(key string, sess siderSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if old, ok := m.sessions[key]; ok && old.CID == sess.CID {
+		sess.CreatedAt = old.CreatedAt
+	} else {
+		sess.CreatedAt = now
+	}
+	sess.LastUsed = now
+	m.sessions[key] = &sess
+}
+
+// Len 返回当前活跃会话数
+func (m *SessionManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.sessions)
+}
+
+func (m *SessionManager) cleanupLoop() {
+	ticker := time.NewTicker(m.ttl / 2)
+	defer ticker.Stop()
+	for range ticker.C {
+		m.cleanup()
+	}
+}
+
+// 清理闲置超过 ttl 的会话
+func (m *SessionManager) cleanup() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, sess := range m.sessions {
+		if time.Since(sess.LastUsed) > m.ttl {
+			delete(m.sessions, key)
+			fmt.Printf("清理过期会话: %s\n", key)
+		}
+	}
+}
+
+// sessionKey 返回请求的会话标识 id (通过 X-Session-ID 响应头返回给客户端) 与会话存储中使用的 key.
+// 优先使用客户端显式传入的 X-Session-ID, 存储时加上调用方 API Key 的摘要作为前缀, 避免不同 Key 猜中同一 ID 接续他人的会话;
+// 否则用「客户端 API Key + system + 第一条用户消息」推导: 同一对话的所有轮次共享这一前缀.
+func sessionKey(r *http.Request, messages []Message) (id, key string) {
+	if id := strings.TrimSpace(r.Header.Get("X-Session-ID")); id != "" {
+		owner := clientToken(r)
+		if identity := identityFrom(r); identity != nil {
+			owner = identity.Key
+		}
+		return id, hashText(owner)[:16] + ":" + id
+	}
+
+	var system, firstUser string
+	for _, m := range messages {
+		if (m.Role == "system" || m.Role == "developer") && system == "" {
+			system = m.Content
+		}
+		if m.Role == "user" && firstUser == "" {
+			firstUser = m.Content
+		}
+	}
+	id = "conv-" + hashText(clientToken(r) + "|" + system + "|" + firstUser)[:16]
+	return id, id
+}
+
+// hashMessages 计算一组消息的摘要. 函数调用在转发前已渲染进 content, 图片与思考过程不参与比较
+func hashMessages(messages []Message) string {
+	h := sha1.New()
+	for _, m := range messages {
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d:%s\x00", m.Role, m.Name, m.ToolCallID, len(m.Content), m.Content)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hashText(text string) string {
+	sum := sha1.Sum([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
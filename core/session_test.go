@@ -0,0 +1,46 @@
+package core
+
+import "testing"
+
+func TestSessionContinues(t *testing.T) {
+	history := []Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "What's the weather in Paris?"},
+		{Role: "assistant", Content: "Let me check.\n<tool_calls>[{\"name\":\"weather\"}]</tool_calls>"},
+	}
+	sess := siderSession{CID: "c-1", Turns: len(history), PrefixHash: hashMessages(history)}
+	with := func(change func(messages []Message)) []Message {
+		messages := append(append([]Message(nil), history...), Message{Role: "tool", ToolCallID: "call_1", Content: "[Tool Result] sunny"})
+		if change != nil {
+			change(messages)
+		}
+		return messages
+	}
+
+	tests := []struct {
+		name     string
+		messages []Message
+		want     bool
+	}{
+		{name: "历史原样发回并追加新消息", messages: with(nil), want: true},
+		{name: "没有新消息", messages: history, want: false},
+		{name: "改写了较早的消息", messages: with(func(m []Message) { m[1].Content = "What's the weather in Rome?" }), want: false},
+		{name: "改写了 system", messages: with(func(m []Message) { m[0].Content = "Be verbose." }), want: false},
+		{name: "回复与实际返回的不同", messages: with(func(m []Message) { m[2].Content = "Let me check." }), want: false},
+		{name: "角色不同", messages: with(func(m []Message) { m[1].Role = "developer" }), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sess.continues(tt.messages); got != tt.want {
+				t.Errorf("continues = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// 内容拼接不同但连起来相同的消息不应得到相同的摘要
+	a := []Message{{Role: "user", Content: "ab"}, {Role: "user", Content: "c"}}
+	b := []Message{{Role: "user", Content: "a"}, {Role: "user", Content: "bc"}}
+	if hashMessages(a) == hashMessages(b) {
+		t.Error("消息边界不同时摘要不应相同")
+	}
+}
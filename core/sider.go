@@ -0,0 +1,575 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// 创建访问 Sider 的 HTTP 客户端, 配置了 PROXY_ADDR/PROXY_PORT 时走 SOCKS5 代理
+func createHTTPClient(cfg Config) (*http.Client, error) {
+	// 检查是否配置了代理
+	if cfg.ProxyAddr == "" || cfg.ProxyPort == "" {
+		return &http.Client{}, nil
+	}
+
+	// 构建代理地址
+	proxyURL := &url.URL{
+		Scheme: "socks5",
+		Host:   fmt.Sprintf("%s:%s", cfg.ProxyAddr, cfg.ProxyPort),
+	}
+	if cfg.ProxyUser != "" && cfg.ProxyPassword != "" {
+		proxyURL.User = url.UserPassword(cfg.ProxyUser, cfg.ProxyPassword)
+	}
+	fmt.Printf("使用SOCKS5代理: %s\n", proxyURL.Host)
+
+	// net/http 原生支持 socks5 代理, 无需额外依赖
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyURL(proxyURL),
+		},
+	}, nil
+}
+
+// 设置CORS头
+func setCORSHeaders(w http.ResponseWriter, methods string) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Methods", methods)
+	w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Session-ID, x-api-key, anthropic-version, x-goog-api-key")
+	w.Header().Set("Access-Control-Expose-Headers", "X-Session-ID, X-Model, X-Context-Strategy, X-Conversation-ID, Retry-After, x-ratelimit-limit-requests, x-ratelimit-remaining-requests, x-ratelimit-reset-requests, x-ratelimit-limit-tokens, x-ratelimit-remaining-tokens, x-ratelimit-reset-tokens")
+}
+
+// flush 在 ResponseWriter 支持时立即刷新 (vercel 等环境可能不支持)
+func flush(w http.ResponseWriter) {
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// writeKeepAlive 收到上游 pulse 时向客户端写入 SSE 注释, 防止空闲连接被中间层断开
+func writeKeepAlive(w http.ResponseWriter) bool {
+	if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+		fmt.Printf("写入响应失败: %v\n", err)
+		return false
+	}
+	flush(w)
+	return true
+}
+
+// readSiderStream 逐行读取 Sider 的 SSE 响应, 对每个解析成功的事件调用 fn.
+// 遇到 [DONE] 或 fn 返回 false 时停止. 兼容非流式时返回的单个 JSON 体.
+func readSiderStream(body io.Reader, fn func(SiderEvent) bool) error {
+	reader := bufio.NewReader(body)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		// 去除前缀和空白字符
+		data := strings.TrimSpace(line)
+		data = strings.TrimSpace(strings.TrimPrefix(data, "data:"))
+
+		if data == "[DONE]" {
+			return nil
+		}
+		if data != "" {
+			if ev, jsonErr := decodeSiderEvent([]byte(data)); jsonErr != nil {
+				fmt.Printf("解析Sider响应失败: %v\n", jsonErr)
+			} else if !fn(ev) {
+				return nil
+			}
+		}
+
+		if err == io.EOF {
+			return nil
+		}
+	}
+}
+
+// 发往 Sider 的单次请求参数
+type siderRequest struct {
+	Prompt          string
+	Model           string
+	Stream          bool
+	CID             string // 为空时上游新建会话
+	ParentMessageID string
+	ThinkMode       bool
+	Tools           []string // 启用的上游内置工具, 为空时使用默认模板
+}
+
+// thinkModeFor 判断是否开启上游 think 模式: 显式的 reasoning_effort 优先, 否则按注册表中的 think 标记
+func thinkModeFor(model ModelInfo, effort string) bool {
+	if effort != "" {
+		return effort != "none"
+	}
+	return model.Think
+}
+
+// 构建发往 Sider 的请求体
+func (p siderRequest) body() ([]byte, error) {
+	// 解析默认模板
+	var defaultConfig map[string]interface{}
+	if err := json.Unmarshal(defaultJsonTemplate, &defaultConfig); err != nil {
+		return nil, fmt.Errorf("解析默认配置失败: %v", err)
+	}
+
+	defaultConfig["prompt"] = p.Prompt
+	defaultConfig["model"] = p.Model
+	defaultConfig["stream"] = p.Stream
+	defaultConfig["think_mode"] = map[string]bool{"enable": p.ThinkMode}
+	if len(p.Tools) > 0 {
+		defaultConfig["tools"] = map[string][]string{"auto": p.Tools}
+	}
+	if p.CID != "" {
+		defaultConfig["cid"] = p.CID
+		defaultConfig["parent_message_id"] = p.ParentMessageID
+	}
+
+	return json.Marshal(defaultConfig)
+}
+
+// 创建转发到Sider的请求
+func (s *Server) newSiderRequest(body []byte, token string) (*http.Request, error) {
+	req, err := http.NewRequest("POST", s.cfg.SiderURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	// 设置请求头
+	req.Header.Set("accept", "*/*")
+	req.Header.Set("accept-language", "zh-CN,zh;q=0.9,en;q=0.8,en-GB;q=0.7,en-US;q=0.6")
+	req.Header.Set("authorization", "Bearer "+token)
+	req.Header.Set("content-type", "application/json")
+	req.Header.Set("origin", "chrome-extension://dhoenijjpgpeimemopealfcbiecgceod")
+	req.Header.Set("user-agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/129.0.0.0 Safari/537.36 Edg/129.0.0.0")
+	return req, nil
+}
+
+// completion 是一次已发往 Sider 的对话请求. OpenAI / Anthropic 等协议共用
+// startCompletion 完成会话复用、prompt 拼接与上游调用, 只在输出格式上各自处理.
+type completion struct {
+	s          *Server
+	userReq    *UserRequest
+	Model      string // 实际使用的模型 id
+	FellBack   bool   // 是否因主模型出错改用了回退模型
+	Prompt     string
+	Stream     bool
+	SessionID  string // 返回给客户端的会话标识
+	SessionKey string // 会话存储中的 key, 显式传入的 X-Session-ID 带有调用方前缀
+
+	tok         Tokenizer   // 模型对应的 tokenizer, 用于统计 usage
+	messages    []Message   // 经上下文管理后的完整对话, 用于统计 usage
+	strategy    string      // 对话超出预算时使用的上下文管理策略
+	rateLimit   http.Header // 通过限流检查时的 x-ratelimit-* 响应头
+	path        string      // 请求路径与调用方, 用于请求统计
+	caller      string
+	account     *upstreamAccount
+	upstreamErr *apiError // 流内收到的上游错误, 归还账号时使用
+	resp        *http.Response
+	start       *SiderMessageStart
+	text        strings.Builder
+	reasoning   strings.Builder
+	files       []SiderFile
+	credits     json.RawMessage
+	reply       *Message // 实际返回给客户端的 assistant 消息, 为空时即上游原文
+}
+
+// startCompletion 将协议无关的 UserRequest 发往 Sider, 并计入请求统计
+func (s *Server) startCompletion(r *http.Request, userReq *UserRequest) (*completion, *apiError) {
+	fmt.Printf("收到新请求: %s %s (key: %s)\n", r.Method, r.URL.Path, identityName(r))
+
+	c, apiErr := s.openCompletion(r, userReq)
+	if apiErr != nil {
+		requested := userReq.Model
+		if requested == "" {
+			requested = s.cfg.DefaultModel
+		}
+		model := unknownModelStats
+		if info, ok := s.models.Resolve(requested); ok {
+			model = info.ID
+		}
+		if userReq.charged {
+			s.stats.failFollow(r.URL.Path, model, identityName(r), apiErr)
+		} else {
+			s.stats.fail(r.URL.Path, model, identityName(r), apiErr)
+		}
+		return nil, apiErr
+	}
+	c.path, c.caller = r.URL.Path, identityName(r)
+	if userReq.charged {
+		s.stats.follow()
+	} else {
+		s.stats.begin(c.Model, c.caller)
+	}
+	return c, nil
+}
+
+// checkRequest 执行请求上游之前的本地检查: 模型存在且支持对话、不含图片、Key 有权使用该模型、已配置账号.
+// openCompletion 与 /v1/count_tokens 共用, 两者的判断保持一致. 模型不存在时 info 为零值
+func (s *Server) checkRequest(r *http.Request, userReq *UserRequest) (ModelInfo, *apiError) {
+	requested := s.cfg.DefaultModel
+	if userReq.Model != "" {
+		requested = userReq.Model
+	}
+	info, ok := s.models.Resolve(requested)
+	if !ok {
+		return ModelInfo{}, modelNotFound(requested)
+	}
+	// 图片生成请求 (指定了上游工具) 也经过这里, 只有普通对话要求模型支持 chat
+	if len(userReq.siderTools) == 0 && !info.Has(CapabilityChat) {
+		return info, &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "model_not_supported",
+			Message: fmt.Sprintf("模型 %s 不支持对话, 图片模型请使用 /v1/images/generations", info.ID)}
+	}
+	if hasImages(userReq.Messages) {
+		return info, visionNotSupported(info.ID)
+	}
+	if identity := identityFrom(r); identity != nil && !identity.AllowsModel(info.ID) && !identity.AllowsModel(requested) {
+		return info, &apiError{Status: http.StatusForbidden, Type: "permission_error", Code: "model_not_allowed",
+			Message: fmt.Sprintf("API key '%s' 无权使用模型 %s", identity.Name, info.ID)}
+	}
+	if s.tokens.Len() == 0 {
+		fmt.Println("Error: SIDER_AUTH_TOKEN environment variable not set.")
+		return info, newAPIError(http.StatusInternalServerError, "server_error", "服务器配置错误: Sider Token 未设置")
+	}
+	return info, nil
+}
+
+// openCompletion 校验模型/权限/限流后发送请求.
+// 模型出错时按回退链换模型, 账号出错时换账号 (见 sendWithAccounts).
+func (s *Server) openCompletion(r *http.Request, userReq *UserRequest) (*completion, *apiError) {
+	info, apiErr := s.checkRequest(r, userReq)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	identity := identityFrom(r)
+
+	// 本地检查全部通过后, 在第一次请求上游 (含上下文摘要) 之前计入限流与配额.
+	// 限流在计入配额之前检查, 被限流的请求不消耗配额
+	var rateLimit http.Header
+	if !userReq.charged {
+		rateLimit, apiErr = s.limiter.Allow(identity, countMessages(s.tokenizers.For(info), userReq.Messages)+userReq.MaxTokens)
+		if apiErr != nil {
+			fmt.Printf("请求被限流 (key: %s): %s\n", identityName(r), apiErr.Message)
+			return nil, apiErr
+		}
+		if key := identity; key != nil && !s.keys.Consume(key.Key) {
+			return nil, &apiError{Status: http.StatusTooManyRequests, Type: "insufficient_quota", Code: "insufficient_quota",
+				Message: fmt.Sprintf("API key '%s' 的配额已用完", key.Name)}
+		}
+	}
+
+	// 同一对话的后续轮次复用上游会话, 只发送新增的消息. 上游会话属于创建它的账号
+	messages := userReq.Messages
+	sessionID, key := sessionKey(r, messages)
+	var sess siderSession
+	resumable := false
+	if s.cfg.SessionTTL > 0 && !userReq.internal {
+		sess, resumable = s.sessions.Get(key)
+		resumable = resumable && sess.continues(messages)
+	}
+	// 复用会话时上游已有历史, 只有新建会话才需要按模型的输入预算处理完整历史
+	strategy := ""
+	if !resumable && !userReq.internal {
+		messages, strategy = s.fitContext(r, info, userReq, messages)
+	}
+
+	// 上游拒绝或出错时按回退链依次改用其他模型, 跳过 Key 无权使用的模型
+	failed := ""
+	for i, m := range s.models.Chain(info) {
+		if i > 0 {
+			if identity != nil && !identity.AllowsModel(m.ID) {
+				continue
+			}
+			fmt.Printf("模型 %s 请求失败, 回退到 %s\n", failed, m.ID)
+		}
+		// 启用上游工具 (图片生成) 时必须以流式请求才能收到 file 事件, 结果收集完才返回, 不受 FORCE_NON_STREAM 影响
+		base := siderRequest{
+			Model:     m.Upstream,
+			Stream:    userReq.Stream && (!s.cfg.ForceNonStream || len(userReq.siderTools) > 0),
+			ThinkMode: thinkModeFor(m, userReq.ReasoningEffort),
+			Tools:     userReq.siderTools,
+		}
+		fmt.Printf("使用的模型: %s (上游: %s, think: %v)\n", m.ID, m.Upstream, base.ThinkMode)
+
+		var siderReq siderRequest
+		var account *upstreamAccount
+		var resp *http.Response
+		siderReq, account, resp, apiErr = s.sendWithAccounts(r, base, messages, key, sess, resumable)
+		if apiErr == nil {
+			return &completion{
+				s:          s,
+				userReq:    userReq,
+				Model:      m.ID,
+				FellBack:   i > 0,
+				Prompt:     siderReq.Prompt,
+				Stream:     siderReq.Stream,
+				SessionID:  sessionID,
+				SessionKey: key,
+				tok:        s.tokenizers.For(m),
+				messages:   messages,
+				strategy:   strategy,
+				rateLimit:  rateLimit,
+				account:    account,
+				resp:       resp,
+			}, nil
+		}
+		if !isModelError(apiErr) {
+			break
+		}
+		failed = m.ID
+	}
+	return nil, apiErr
+}
+
+// sendWithAccounts 从账号池中选择账号发送, 账号认证失败或被限流时自动换下一个健康账号重试.
+// 返回实际发送的请求 (含 prompt 与会话信息) 与使用的账号.
+func (s *Server) sendWithAccounts(r *http.Request, base siderRequest, messages []Message, key string, sess siderSession, resumable bool) (siderRequest, *upstreamAccount, *http.Response, *apiError) {
+	preferred := ""
+	if resumable {
+		preferred = sess.Account
+	}
+	tried := make(map[string]bool)
+	var lastErr *apiError
+	var retryAt time.Time
+	for {
+		var account *upstreamAccount
+		account, retryAt = s.tokens.Acquire(preferred, tried)
+		if account == nil {
+			break
+		}
+		tried[account.Token] = true
+
+		siderReq := base
+		pending := messages
+		if resumable && account.Token == sess.Account {
+			siderReq.CID = sess.CID
+			siderReq.ParentMessageID = sess.ParentMessageID
+			pending = messages[sess.Turns:]
+			fmt.Printf("使用现有会话: %s (cid: %s)\n", key, sess.CID)
+		} else if resumable {
+			fmt.Printf("会话 %s 所属账号不可用, 以完整历史新建会话\n", key)
+		}
+		siderReq.Prompt = s.prompt(pending)
+
+		resp, apiErr := s.sendSider(r, siderReq, account)
+		if apiErr == nil {
+			return siderReq, account, resp, nil
+		}
+
+		s.tokens.Release(account, apiErr)
+		if !isAccountError(apiErr) {
+			return siderReq, nil, nil, apiErr
+		}
+		lastErr = apiErr
+		fmt.Printf("账号 %s 请求失败, 尝试下一个账号\n", account.Name())
+	}
+
+	if lastErr != nil {
+		return base, nil, nil, lastErr
+	}
+	// 所有账号都在冷却中
+	apiErr := &apiError{Status: http.StatusServiceUnavailable, Type: "server_error", Code: "upstream_unavailable",
+		Message: "所有上游账号暂时不可用, 请稍后重试"}
+	if wait := retryAt.Sub(s.tokens.now()); wait > 0 {
+		apiErr.RetryAfter = int(wait.Seconds()) + 1
+	}
+	return base, nil, nil, apiErr
+}
+
+// prompt 将待发送的消息拼接为 prompt
+func (s *Server) prompt(messages []Message) string {
+	prompt, truncated := buildPrompt(messages, s.cfg.MaxPromptChars, s.cfg.MaxPromptWords)
+	if prompt == "" {
+		prompt = "你好" // 默认提示词
+	}
+	if truncated {
+		fmt.Printf("对话历史超出上限 (%d 字符 / %d 词), 已丢弃最早的轮次\n", s.cfg.MaxPromptChars, s.cfg.MaxPromptWords)
+	}
+	fmt.Printf("处理的prompt: %s\n", prompt)
+	return prompt
+}
+
+// sendSider 使用指定账号发送请求. 上游在响应开头返回的错误事件 (如 1135 限流) 也在这里识别,
+// 以便调用方换账号重试; 其余内容原样留给 completion 读取.
+func (s *Server) sendSider(r *http.Request, siderReq siderRequest, account *upstreamAccount) (*http.Response, *apiError) {
+	finalBody, err := siderReq.body()
+	if err != nil {
+		fmt.Printf("生成最终请求体失败: %v\n", err)
+		return nil, newAPIError(http.StatusInternalServerError, "server_error", "处理请求失败")
+	}
+
+	req, err := s.newSiderRequest(finalBody, account.Token)
+	if err != nil {
+		fmt.Printf("创建Sider请求失败: %v\n", err)
+		return nil, newAPIError(http.StatusInternalServerError, "server_error", "创建请求失败")
+	}
+	req = req.WithContext(r.Context())
+
+	// 发送请求到Sider
+	resp, err := s.client.Do(req)
+	if err != nil {
+		fmt.Printf("发送到Sider请求失败: %v\n", err)
+		return nil, newAPIError(http.StatusBadGateway, "upstream_error", "发送请求失败")
+	}
+
+	fmt.Printf("Sider响应状态码: %d (账号 %s)\n", resp.StatusCode, account.Name())
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		return nil, upstreamHTTPError(resp)
+	}
+
+	// 读取第一行非空内容, 是错误事件则直接返回, 否则放回供后续读取
+	reader := bufio.NewReader(resp.Body)
+	var consumed strings.Builder
+	for {
+		line, err := reader.ReadString('\n')
+		consumed.WriteString(line)
+		data := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), "data:"))
+		if data != "" {
+			if ev, jsonErr := decodeSiderEvent([]byte(data)); jsonErr == nil && ev.Type == EventError {
+				resp.Body.Close()
+				fmt.Printf("Sider返回错误: code=%d msg=%s\n", ev.Code, ev.Msg)
+				return nil, siderError(ev.Code, ev.Msg)
+			}
+			break
+		}
+		if err != nil {
+			break
+		}
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(strings.NewReader(consumed.String()), reader), resp.Body}
+	return resp, nil
+}
+
+// each 逐个读取上游事件, 同时记录会话信息、完整回复、思考过程、文件与额度信息.
+// 遇到上游错误事件时停止并返回对应的 *apiError.
+func (c *completion) each(fn func(SiderEvent) bool) error {
+	err := readSiderStream(c.resp.Body, func(ev SiderEvent) bool {
+		switch ev.Type {
+		case EventError:
+			fmt.Printf("Sider返回错误: code=%d msg=%s\n", ev.Code, ev.Msg)
+			c.upstreamErr = siderError(ev.Code, ev.Msg)
+			return false
+		case EventMessageStart:
+			if ev.MessageStart != nil {
+				c.start = ev.MessageStart
+			}
+		case EventReasoning:
+			c.reasoning.WriteString(ev.Text)
+		case EventFile:
+			if ev.File != nil {
+				c.files = append(c.files, *ev.File)
+			}
+		case EventToolCall:
+			if ev.ToolCall != nil {
+				fmt.Printf("工具调用状态: %s %s\n", ev.ToolCall.Name, ev.ToolCall.Status)
+			}
+		case EventCreditInfo:
+			c.credits = ev.CreditInfo
+		}
+		c.text.WriteString(ev.Content())
+		return fn(ev)
+	})
+	if c.upstreamErr != nil {
+		return c.upstreamErr
+	}
+	return err
+}
+
+// Text 返回目前收到的完整回复 (图片以 Markdown 链接的形式包含在内)
+func (c *completion) Text() string {
+	return c.text.String()
+}
+
+// FinishReason 返回结束原因: 回复达到 max_tokens 时为 length, 否则为 stop
+func (c *completion) FinishReason() string {
+	if c.userReq.MaxTokens > 0 && c.tok.Count(c.Text()) >= c.userReq.MaxTokens {
+		return "length"
+	}
+	return "stop"
+}
+
+// PromptTokens 返回输入的 token 数. 复用会话时上游只收到新增的消息, 但模型看到的是完整历史, 因此按全部消息计
+// (超出预算时为上下文管理处理后的消息)
+func (c *completion) PromptTokens() int {
+	return countMessages(c.tok, c.messages)
+}
+
+// CompletionTokens 返回目前收到的回复 (含思考过程) 的 token 数
+func (c *completion) CompletionTokens() int {
+	return c.tok.Count(c.Text()) + c.tok.Count(c.Reasoning())
+}
+
+// Reasoning 返回目前收到的思考过程
+func (c *completion) Reasoning() string {
+	return c.reasoning.String()
+}
+
+// Files 返回上游生成的文件
+func (c *completion) Files() []SiderFile {
+	return c.files
+}
+
+// Credits 返回最近一次 credit_info 事件的原始内容
+func (c *completion) Credits() json.RawMessage {
+	return c.credits
+}
+
+// ConversationID 返回上游会话 ID, 尚未收到 message_start 时为空
+func (c *completion) ConversationID() string {
+	if c.start == nil {
+		return ""
+	}
+	return c.start.CID
+}
+
+// setHeaders 输出会话标识、实际使用的模型、上下文管理策略与限流信息响应头
+func (c *completion) setHeaders(w http.ResponseWriter) {
+	w.Header().Set("X-Session-ID", c.SessionID)
+	w.Header().Set("X-Model", c.Model)
+	if c.strategy != "" {
+		w.Header().Set("X-Context-Strategy", c.strategy)
+	}
+	for k, v := range c.rateLimit {
+		w.Header()[k] = v
+	}
+}
+
+// finish 关闭上游响应, 并保存会话供下一轮复用
+func (c *completion) finish() {
+	c.resp.Body.Close()
+	c.s.tokens.Release(c.account, c.upstreamErr)
+	c.s.stats.end(c.path, c.Model, c.caller, c.upstreamErr)
+
+	if c.s.cfg.SessionTTL <= 0 || c.userReq.internal || c.start == nil || c.start.CID == "" {
+		return
+	}
+	// 按客户端下一轮发回的形式记录历史: 请求中的消息加上实际返回的回复 (函数调用同样渲染为文本)
+	reply := Message{Role: "assistant", Content: c.Text()}
+	if c.reply != nil {
+		reply = *c.reply
+	}
+	prefix := append(append([]Message(nil), c.userReq.Messages...), renderToolMessages([]Message{reply})...)
+	c.s.sessions.Save(c.SessionKey, siderSession{
+		CID:             c.start.CID,
+		ParentMessageID: c.start.AssistantMessageID,
+		Turns:           len(prefix),
+		PrefixHash:      hashMessages(prefix),
+		Account:         c.account.Token,
+	})
+}
+
+// setReply 记录实际返回给客户端的回复 (解析出的函数调用、提取出的 JSON 等), 在 finish 之前调用
+func (c *completion) setReply(message Message) {
+	c.reply = &message
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 保留的最近错误条数
+const recentErrorLimit = 50
+
+// 模型名无法解析时统计使用的名称. 按模型统计只使用注册表中的 id, 客户端随意传入的名称不会让统计无限增长
+const unknownModelStats = "unknown"
+
+// CounterStats 是按模型或按 Key 统计的请求数
+type CounterStats struct {
+	Name     string `json:"name"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+}
+
+// ErrorRecord 是一次失败请求的记录
+type ErrorRecord struct {
+	Time    time.Time `json:"time"`
+	Path    string    `json:"path"`
+	Model   string    `json:"model"`
+	Key     string    `json:"key"`
+	Status  int       `json:"status"`
+	Code    string    `json:"code,omitempty"`
+	Message string    `json:"message"`
+}
+
+// requestStats 统计进程启动以来的补全请求, 供管理界面展示
+type requestStats struct {
+	mu       sync.Mutex
+	started  time.Time
+	requests int64
+	errors   int64
+	inFlight int
+	models   map[string]*CounterStats
+	keys     map[string]*CounterStats
+	recent   []ErrorRecord // 最新的在最后
+}
+
+func newRequestStats() *requestStats {
+	return &requestStats{
+		started: time.Now(),
+		models:  make(map[string]*CounterStats),
+		keys:    make(map[string]*CounterStats),
+	}
+}
+
+func counter(m map[string]*CounterStats, name string) *CounterStats {
+	c, ok := m[name]
+	if !ok {
+		c = &CounterStats{Name: name}
+		m[name] = c
+	}
+	return c
+}
+
+// begin 记录一次已发往上游的请求
+func (st *requestStats) begin(model, key string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.requests++
+	st.inFlight++
+	counter(st.models, model).Requests++
+	counter(st.keys, key).Requests++
+}
+
+// end 记录请求结束, apiErr 为流内收到的上游错误
+func (st *requestStats) end(path, model, key string, apiErr *apiError) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.inFlight--
+	if apiErr != nil {
+		st.recordError(path, model, key, apiErr)
+	}
+}
+
+// fail 记录一次在发往上游之前或发送时就失败的请求
+func (st *requestStats) fail(path, model, key string, apiErr *apiError) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	st.requests++
+	counter(st.models, model).Requests++
+	counter(st.keys, key).Requests++
+	st.recordError(path, model, key, apiErr)
+}
+
+// follow 记录同一客户端请求中的后续上游请求, 只计入进行中的请求
+func (st *requestStats) follow() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.inFlight++
+}
+
+// failFollow 记录后续上游请求的失败, 不重复计请求数
+func (st *requestStats) failFollow(path, model, key string, apiErr *apiError) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.recordError(path, model, key, apiErr)
+}
+
+func (st *requestStats) recordError(path, model, key string, apiErr *apiError) {
+	st.errors++
+	counter(st.models, model).Errors++
+	counter(st.keys, key).Errors++
+
+	st.recent = append(st.recent, ErrorRecord{
+		Time:    time.Now(),
+		Path:    path,
+		Model:   model,
+		Key:     key,
+		Status:  apiErr.Status,
+		Code:    apiErr.Code,
+		Message: apiErr.Message,
+	})
+	if len(st.recent) > recentErrorLimit {
+		st.recent = st.recent[len(st.recent)-recentErrorLimit:]
+	}
+}
+
+// StatsSnapshot 是 /api/admin/stats 的响应
+type StatsSnapshot struct {
+	Status        string          `json:"status"` // ok / degraded / unhealthy, 同 /status
+	StartedAt     time.Time       `json:"started_at"`
+	UptimeSeconds int64           `json:"uptime_seconds"`
+	Requests      int64           `json:"requests"`
+	Errors        int64           `json:"errors"`
+	InFlight      int             `json:"in_flight"`
+	Sessions      int             `json:"sessions"`
+	Models        []CounterStats  `json:"models"` // 按请求数降序
+	Keys          []CounterStats  `json:"keys"`
+	Accounts      []AccountStatus `json:"accounts"`
+	RecentErrors  []ErrorRecord   `json:"recent_errors"` // 最新的在前
+}
+
+// snapshot 返回请求统计部分的快照
+func (st *requestStats) snapshot() StatsSnapshot {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	snap := StatsSnapshot{
+		StartedAt:     st.started,
+		UptimeSeconds: int64(time.Since(st.started).Seconds()),
+		Requests:      st.requests,
+		Errors:        st.errors,
+		InFlight:      st.inFlight,
+		Models:        sortedCounters(st.models),
+		Keys:          sortedCounters(st.keys),
+		RecentErrors:  make([]ErrorRecord, 0, len(st.recent)),
+	}
+	for i := len(st.recent) - 1; i >= 0; i-- {
+		snap.RecentErrors = append(snap.RecentErrors, st.recent[i])
+	}
+	return snap
+}
+
+func sortedCounters(m map[string]*CounterStats) []CounterStats {
+	list := make([]CounterStats, 0, len(m))
+	for _, c := range m {
+		list = append(list, *c)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Requests != list[j].Requests {
+			return list[i].Requests > list[j].Requests
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
@@ -0,0 +1,268 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenAI response_format: text / json_object / json_schema
+type ResponseFormat struct {
+	Type       string `json:"type"`
+	JSONSchema *struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description"`
+		Schema      json.RawMessage `json:"schema"`
+		Strict      bool            `json:"strict"`
+	} `json:"json_schema"`
+}
+
+// responseFormat 是一次请求要求的结构化输出
+type responseFormat struct {
+	schema *jsonSchema // 仅 json_schema
+}
+
+// prepareResponseFormat 校验 response_format 并将格式要求写入 system 消息. 未要求 JSON 时返回 nil
+func prepareResponseFormat(userReq *UserRequest) (*responseFormat, *apiError) {
+	rf := userReq.ResponseFormat
+	if rf == nil || rf.Type == "" || rf.Type == "text" {
+		return nil, nil
+	}
+
+	prompt := "[Response Format]\nReply with a single valid JSON object only. Do not wrap it in markdown code fences and do not add any other text."
+	format := &responseFormat{}
+	switch rf.Type {
+	case "json_object":
+	case "json_schema":
+		if rf.JSONSchema == nil || len(rf.JSONSchema.Schema) == 0 {
+			return nil, invalidResponseFormat("json_schema 类型需要提供 json_schema.schema")
+		}
+		schema, err := newJSONSchema(rf.JSONSchema.Schema)
+		if err != nil {
+			return nil, invalidResponseFormat(err.Error())
+		}
+		format.schema = schema
+		var compact bytes.Buffer
+		json.Compact(&compact, rf.JSONSchema.Schema)
+		prompt += "\nThe JSON must conform to this JSON Schema"
+		if rf.JSONSchema.Name != "" {
+			prompt += fmt.Sprintf(" (%s", rf.JSONSchema.Name)
+			if rf.JSONSchema.Description != "" {
+				prompt += ": " + rf.JSONSchema.Description
+			}
+			prompt += ")"
+		}
+		prompt += ":\n" + compact.String()
+	default:
+		return nil, invalidResponseFormat("response_format.type 只支持 text / json_object / json_schema")
+	}
+
+	userReq.Messages = append([]Message{{Role: "system", Content: prompt}}, userReq.Messages...)
+	return format, nil
+}
+
+func invalidResponseFormat(message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "invalid_response_format", Message: message}
+}
+
+// extract 从回复中取出 JSON 并校验, 返回压缩后的 JSON 文本
+func (f *responseFormat) extract(text string) (string, error) {
+	raw := extractJSON(text)
+	if raw == "" {
+		return "", fmt.Errorf("回复中没有 JSON 对象")
+	}
+	var v interface{}
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return "", fmt.Errorf("JSON 格式错误: %v", err)
+	}
+	if _, ok := v.(map[string]interface{}); !ok && f.schema == nil {
+		return "", fmt.Errorf("回复不是 JSON 对象")
+	}
+	if f.schema != nil {
+		if err := f.schema.Validate(v); err != nil {
+			return "", fmt.Errorf("不符合 schema: %v", err)
+		}
+	}
+	var compact bytes.Buffer
+	json.Compact(&compact, []byte(raw))
+	return compact.String(), nil
+}
+
+// extractJSON 去掉 markdown 代码块, 返回第一个完整的 JSON 对象或数组; 括号不匹配时返回从开头到结尾的内容, 由解析报错
+func extractJSON(text string) string {
+	if start := strings.Index(text, "```"); start >= 0 {
+		block := text[start+3:]
+		if nl := strings.IndexByte(block, '\n'); nl >= 0 && !strings.ContainsAny(block[:nl], "{[") {
+			block = block[nl+1:] // 跳过 ```json 这一行
+		}
+		if end := strings.Index(block, "```"); end >= 0 {
+			block = block[:end]
+		}
+		text = block
+	}
+
+	start := strings.IndexAny(text, "{[")
+	if start < 0 {
+		return ""
+	}
+	depth, inString, escaped := 0, false, false
+	for i := start; i < len(text); i++ {
+		ch := text[i]
+		switch {
+		case escaped:
+			escaped = false
+		case inString:
+			if ch == '\\' {
+				escaped = true
+			} else if ch == '"' {
+				inString = false
+			}
+		case ch == '"':
+			inString = true
+		case ch == '{' || ch == '[':
+			depth++
+		case ch == '}' || ch == ']':
+			depth--
+			if depth == 0 {
+				return text[start : i+1]
+			}
+		}
+	}
+	return strings.TrimSpace(text[start:])
+}
+
+// repairPrompt 是校验失败后要求模型修正的消息
+func repairPrompt(err error) string {
+	return fmt.Sprintf("Your previous reply is not valid: %v\nReply again with only the corrected JSON, no other text.", err)
+}
+
+// forwardStructured 处理带 response_format 的 Chat Completions 请求: 完整读取回复并校验 JSON,
+// 不合格时在同一对话中要求模型修正, 最多重试 JSON_REPAIR_RETRIES 次.
+// 修正请求属于同一客户端请求, 不再计入限流、配额与请求数. 流式请求在校验通过后一次性输出.
+func (s *Server) forwardStructured(w http.ResponseWriter, r *http.Request, userReq *UserRequest, tc *toolCalling, format *responseFormat) {
+	id := fmt.Sprintf("chatcmpl-%d", time.Now().UnixNano())
+	created := time.Now().Unix()
+	var usage OpenAIUsage
+	var rateLimit http.Header
+
+	for attempt := 0; ; attempt++ {
+		c, apiErr := s.startCompletion(r, userReq)
+		if apiErr != nil {
+			writeAPIError(w, apiErr)
+			return
+		}
+		// 响应头中的限流信息以第一次 (计入限流的) 请求为准
+		if attempt == 0 {
+			rateLimit = c.rateLimit
+		} else {
+			c.rateLimit = rateLimit
+		}
+		// 会话在确定实际返回的回复后再保存, 修正请求沿用上游原文
+		err := c.each(func(SiderEvent) bool { return true })
+		if err != nil {
+			c.finish()
+			fmt.Printf("读取响应失败: %v\n", err)
+			writeAPIError(w, toAPIError(err))
+			return
+		}
+
+		prompt, completion := c.PromptTokens(), c.CompletionTokens()
+		usage.PromptTokens += prompt
+		usage.CompletionTokens += completion
+		usage.TotalTokens += prompt + completion
+		usage.CreditInfo = c.Credits()
+
+		message := Message{Role: "assistant", Content: c.Text(), ReasoningContent: c.Reasoning()}
+		finishReason := c.FinishReason()
+
+		// 模型调用函数时直接返回调用, 只有最终回答需要符合格式
+		if tc != nil {
+			content, calls, err := tc.parse(message.Content)
+			if err != nil && tc.fallback == ToolFallbackError {
+				c.finish()
+				fmt.Printf("解析函数调用失败: %v\n", err)
+				writeAPIError(w, toolParseError(err))
+				return
+			}
+			if len(calls) > 0 {
+				message.Content, message.ToolCalls = content, calls
+				c.setReply(message)
+				c.finish()
+				c.setHeaders(w)
+				writeChatCompletion(w, c, id, created, message, "tool_calls", usage)
+				return
+			}
+		}
+
+		result, err := format.extract(message.Content)
+		if err == nil {
+			message.Content = result
+			c.setReply(message)
+			c.finish()
+			c.setHeaders(w)
+			writeChatCompletion(w, c, id, created, message, finishReason, usage)
+			return
+		}
+		c.finish()
+		if attempt >= s.cfg.JSONRepairRetries {
+			fmt.Printf("结构化输出校验失败, 已重试 %d 次: %v\n", attempt, err)
+			writeAPIError(w, &apiError{Status: http.StatusBadGateway, Type: "upstream_error", Code: "invalid_json_output",
+				Message: fmt.Sprintf("模型未能输出符合要求的 JSON (已重试 %d 次): %v", attempt, err)})
+			return
+		}
+
+		// 修正请求接在原对话之后, 会话复用时只需发送修正要求
+		fmt.Printf("结构化输出校验失败, 要求模型修正 (第 %d 次): %v\n", attempt+1, err)
+		userReq.Messages = append(userReq.Messages,
+			Message{Role: "assistant", Content: c.Text()},
+			Message{Role: "user", Content: repairPrompt(err)})
+		userReq.charged = true
+	}
+}
+
+// writeChatCompletion 输出已完整生成的回复, 流式请求输出为 role / 正文 / 结束三个分块
+func writeChatCompletion(w http.ResponseWriter, c *completion, id string, created int64, message Message, finishReason string, usage OpenAIUsage) {
+	if !c.Stream {
+		w.Header().Set("X-Conversation-ID", c.ConversationID())
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(OpenAIResponse{
+			ID:      id,
+			Object:  "chat.completion",
+			Created: created,
+			Model:   c.Model,
+			Choices: []OpenAIChoice{{Message: message, FinishReason: finishReason, Index: 0}},
+			Usage:   usage,
+		})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	for i := range message.ToolCalls {
+		index := i
+		message.ToolCalls[i].Index = &index
+	}
+	chunks := []OpenAIStreamResponse{
+		{Choices: []OpenAIStreamChoice{{Delta: OpenAIDelta{Role: "assistant"}}}},
+		{Choices: []OpenAIStreamChoice{{Delta: OpenAIDelta{Content: message.Content, ReasoningContent: message.ReasoningContent, ToolCalls: message.ToolCalls}}}},
+		{Choices: []OpenAIStreamChoice{{Delta: OpenAIDelta{}, FinishReason: &finishReason}}},
+	}
+	if c.userReq.StreamOptions != nil && c.userReq.StreamOptions.IncludeUsage {
+		chunks = append(chunks, OpenAIStreamResponse{Choices: []OpenAIStreamChoice{}, Usage: &usage})
+	}
+	for _, chunk := range chunks {
+		chunk.ID, chunk.Object, chunk.Created, chunk.Model = id, "chat.completion.chunk", created, c.Model
+		payload, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", payload)
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+	flush(w)
+}
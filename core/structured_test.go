@@ -0,0 +1,72 @@
+package core
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestExtractJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{name: "纯对象", text: `{"a": 1}`, want: `{"a": 1}`},
+		{name: "前后有说明文字", text: "Here you go: {\"a\": 1} hope it helps", want: `{"a": 1}`},
+		{name: "json 代码块", text: "```json\n{\"a\": [1, 2]}\n```", want: `{"a": [1, 2]}`},
+		{name: "无语言标记的代码块", text: "Result:\n```\n[1, 2]\n```\nDone.", want: `[1, 2]`},
+		{name: "代码块与对象同一行", text: "```{\"a\": 1}```", want: `{"a": 1}`},
+		{name: "字符串中的括号", text: `{"a": "}{][", "b": 2} {"c": 3}`, want: `{"a": "}{][", "b": 2}`},
+		{name: "字符串中的转义引号", text: `{"a": "say \"}\" \\", "b": {}} tail`, want: `{"a": "say \"}\" \\", "b": {}}`},
+		{name: "数组", text: `ids: [1, [2, 3]], more`, want: `[1, [2, 3]]`},
+		{name: "括号不匹配返回剩余内容", text: "{\"a\": {\"b\": 1} \n", want: `{"a": {"b": 1}`},
+		{name: "没有 JSON", text: "no json here", want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := extractJSON(tt.text); got != tt.want {
+				t.Errorf("extractJSON(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResponseFormatExtract(t *testing.T) {
+	schema, err := newJSONSchema(json.RawMessage(`{"type": "object", "required": ["name"], "properties": {"name": {"type": "string"}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		schema  *jsonSchema
+		text    string
+		want    string
+		wantErr string
+	}{
+		{name: "json_object 压缩输出", text: "```json\n{\n  \"a\": 1\n}\n```", want: `{"a":1}`},
+		{name: "json_object 不接受数组", text: "[1, 2]", wantErr: "不是 JSON 对象"},
+		{name: "没有 JSON", text: "sorry", wantErr: "没有 JSON"},
+		{name: "JSON 格式错误", text: `{"a": }`, wantErr: "JSON 格式错误"},
+		{name: "符合 schema", schema: schema, text: `{"name": "x"}`, want: `{"name":"x"}`},
+		{name: "不符合 schema", schema: schema, text: `{"name": 1}`, wantErr: "不符合 schema"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &responseFormat{schema: tt.schema}
+			got, err := f.extract(tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("extract = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
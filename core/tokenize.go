@@ -0,0 +1,179 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+// TokenCount 是 /v1/tokenize 与 /v1/count_tokens 的响应.
+// 按与实际转发相同的规则拼接 prompt 并计数, 不请求上游.
+type TokenCount struct {
+	Object      string `json:"object"` // token_count
+	Model       string `json:"model"`
+	Encoding    string `json:"encoding"`
+	InputTokens int    `json:"input_tokens"` // 完整对话的 token 数, 与响应 usage 中的输入 token 相同
+	Characters  int    `json:"characters"`   // 完整对话拼接后的字符数
+	Words       int    `json:"words"`        // 完整对话拼接后的词数 (中日韩字符各计 1 词)
+
+	Prompt          TokenCountPrompt   `json:"prompt"` // 经上下文管理与截断后实际发往上游的 prompt
+	Limits          TokenCountLimits   `json:"limits"`
+	ContextStrategy string             `json:"context_strategy,omitempty"` // 超出预算时会使用的上下文管理策略
+	Truncated       bool               `json:"truncated"`                  // 是否会丢弃或截短消息
+	Rejected        bool               `json:"rejected"`                   // 是否会被拒绝
+	Error           *OpenAIErrorDetail `json:"error,omitempty"`            // 被拒绝时实际请求会收到的错误
+}
+
+type TokenCountPrompt struct {
+	Tokens     int    `json:"tokens"`
+	Characters int    `json:"characters"`
+	Words      int    `json:"words"`
+	Text       string `json:"text,omitempty"` // 仅 /v1/tokenize 返回
+}
+
+type TokenCountLimits struct {
+	MaxCharacters int `json:"max_characters"`           // MAX_PROMPT_CHARS
+	MaxWords      int `json:"max_words"`                // MAX_PROMPT_WORDS
+	ContextLength int `json:"context_length,omitempty"` // 模型上下文窗口, 未知时省略
+}
+
+// tokenizeHandler 处理 POST /v1/tokenize, 额外返回拼接后的 prompt 原文
+func (s *Server) tokenizeHandler(w http.ResponseWriter, r *http.Request) {
+	s.tokenCountHandler(w, r, true)
+}
+
+// countTokensHandler 处理 POST /v1/count_tokens
+func (s *Server) countTokensHandler(w http.ResponseWriter, r *http.Request) {
+	s.tokenCountHandler(w, r, false)
+}
+
+// tokenCountHandler 接受 OpenAI Chat Completions 或 Anthropic Messages 请求体:
+// 带 anthropic-version 头或顶层 system 字段时按 Anthropic 解析, 错误也以对应格式返回
+func (s *Server) tokenCountHandler(w http.ResponseWriter, r *http.Request, withPrompt bool) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "读取请求失败", "")
+		return
+	}
+	defer r.Body.Close()
+
+	anthropic := r.Header.Get("anthropic-version") != ""
+	if !anthropic {
+		var probe struct {
+			System json.RawMessage `json:"system"`
+		}
+		json.Unmarshal(body, &probe)
+		anthropic = len(probe.System) > 0
+	}
+	writeError := func(apiErr *apiError) {
+		if anthropic {
+			writeAnthropicError(w, apiErr.Status, anthropicErrorType(apiErr.Status), apiErr.Message)
+			return
+		}
+		writeAPIError(w, apiErr)
+	}
+
+	var userReq *UserRequest
+	if anthropic {
+		var req AnthropicRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			writeError(newAPIError(http.StatusBadRequest, "invalid_request_error", "解析请求失败"))
+			return
+		}
+		userReq = req.toUserRequest()
+	} else {
+		userReq = &UserRequest{}
+		if err := json.Unmarshal(body, userReq); err != nil {
+			writeError(newAPIError(http.StatusBadRequest, "invalid_request_error", "解析请求失败"))
+			return
+		}
+		// 函数定义与格式要求同样写入 system, 计入 prompt
+		if _, apiErr := s.prepareTools(userReq); apiErr != nil {
+			writeError(apiErr)
+			return
+		}
+		if _, apiErr := prepareResponseFormat(userReq); apiErr != nil {
+			writeError(apiErr)
+			return
+		}
+	}
+	if len(userReq.Messages) == 0 {
+		writeError(newAPIError(http.StatusBadRequest, "invalid_request_error", "messages: field required"))
+		return
+	}
+
+	count, prompt, apiErr := s.countTokens(r, userReq)
+	if apiErr != nil {
+		writeError(apiErr)
+		return
+	}
+	if withPrompt {
+		count.Prompt.Text = prompt
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(count)
+}
+
+// countTokens 统计请求的 token / 字符 / 词数, 并判断是否会被截断或拒绝.
+// 按新建会话计算, 即发送完整历史; 实际复用会话时上游只收到新增的消息.
+// summarize 策略需要请求上游, 这里按 drop_oldest 估算处理后的 prompt.
+func (s *Server) countTokens(r *http.Request, userReq *UserRequest) (*TokenCount, string, *apiError) {
+	// 与实际转发相同的本地检查, 模型不存在时无法计数, 直接返回错误
+	info, reason := s.checkRequest(r, userReq)
+	if info.ID == "" {
+		return nil, "", reason
+	}
+	tok := s.tokenizers.For(info)
+
+	full, _ := buildPrompt(userReq.Messages, 0, 0)
+	messages, strategy := userReq.Messages, ""
+	if budget := s.contextBudget(info, userReq.MaxTokens); !budget.fits(messages) {
+		strategy, messages = trimContext(budget, messages, s.cfg.ContextStrategy)
+		if s.cfg.ContextStrategy == ContextSummarize {
+			strategy = ContextSummarize
+		}
+	}
+	prompt, truncated := buildPrompt(messages, s.cfg.MaxPromptChars, s.cfg.MaxPromptWords)
+	count := &TokenCount{
+		Object:      "token_count",
+		Model:       info.ID,
+		Encoding:    tok.Encoding(),
+		InputTokens: countMessages(tok, userReq.Messages),
+		Characters:  utf8.RuneCountInString(full),
+		Words:       estimateWordCount(full),
+		Prompt: TokenCountPrompt{
+			Tokens:     tok.Count(prompt),
+			Characters: utf8.RuneCountInString(prompt),
+			Words:      estimateWordCount(prompt),
+		},
+		Limits: TokenCountLimits{
+			MaxCharacters: s.cfg.MaxPromptChars,
+			MaxWords:      s.cfg.MaxPromptWords,
+			ContextLength: info.ContextLength,
+		},
+		ContextStrategy: strategy,
+		Truncated:       truncated || strategy != "",
+	}
+
+	// 转发时不在本地按长度拒绝: 上下文管理与截断之后, system 与当前问题本身仍超出 Sider 的字符或词数上限时
+	// 上游返回 603. 超出模型上下文窗口只影响上下文管理, 不会被拒绝
+	if reason == nil {
+		switch {
+		case s.cfg.MaxPromptChars > 0 && count.Prompt.Characters > s.cfg.MaxPromptChars:
+			reason = contextLengthExceeded(fmt.Sprintf("丢弃历史后仍有 %d 字符, 超出上限 %d", count.Prompt.Characters, s.cfg.MaxPromptChars))
+		case s.cfg.MaxPromptWords > 0 && count.Prompt.Words > s.cfg.MaxPromptWords:
+			reason = contextLengthExceeded(fmt.Sprintf("丢弃历史后仍有 %d 词, 超出上限 %d", count.Prompt.Words, s.cfg.MaxPromptWords))
+		}
+	}
+	if reason != nil {
+		detail := newOpenAIError(reason.Type, reason.Message, reason.Code).Error
+		count.Rejected, count.Error = true, &detail
+	}
+	return count, prompt, nil
+}
+
+func contextLengthExceeded(message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "context_length_exceeded", Message: message}
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	if cfg.DefaultModel == "" {
+		cfg.DefaultModel = "gpt-4o"
+	}
+	s, err := NewServer(cfg)
+	if err != nil {
+		t.Fatalf("NewServer: %v", err)
+	}
+	return s
+}
+
+// countTokens 与实际转发使用相同的本地检查, 只有上下文管理后仍超出 Sider 上限时才判为拒绝
+func TestCountTokens(t *testing.T) {
+	long := strings.Repeat("word ", 200)
+	history := []Message{
+		{Role: "user", Content: long},
+		{Role: "assistant", Content: long},
+		{Role: "user", Content: "latest question"},
+	}
+	accounts := []string{"token-a"}
+
+	tests := []struct {
+		name      string
+		cfg       Config
+		key       *APIKey
+		req       UserRequest
+		wantErr   string // 直接返回的错误码
+		rejected  string // 报告的拒绝原因, 为空表示不会被拒绝
+		truncated bool
+	}{
+		{name: "模型不存在", cfg: Config{SiderTokens: accounts}, req: UserRequest{Model: "no-such-model", Messages: history},
+			wantErr: "model_not_found"},
+		{name: "图片模型不支持对话", cfg: Config{SiderTokens: accounts}, req: UserRequest{Model: "dalle_3_HD", Messages: history},
+			rejected: "model_not_supported"},
+		{name: "Key 无权使用模型", cfg: Config{SiderTokens: accounts}, key: &APIKey{Name: "alice", AllowedModels: []string{"claude-*"}},
+			req: UserRequest{Model: "gpt-4o", Messages: history}, rejected: "model_not_allowed"},
+		{name: "未配置账号", req: UserRequest{Model: "gpt-4o", Messages: history}, rejected: "server_error"},
+		{name: "未超出预算", cfg: Config{SiderTokens: accounts}, req: UserRequest{Model: "gpt-4o", Messages: history}},
+		{name: "超出上下文窗口时丢弃历史而不是拒绝", cfg: Config{SiderTokens: accounts},
+			req: UserRequest{Model: "gpt-4o", Messages: history, MaxTokens: 128000 - 50}, truncated: true},
+		{name: "当前问题本身超出字符上限", cfg: Config{SiderTokens: accounts, MaxPromptChars: 500, ContextStrategy: ContextDropOldest},
+			req:      UserRequest{Model: "gpt-4o", Messages: append(history[:2:2], Message{Role: "user", Content: long})},
+			rejected: "context_length_exceeded", truncated: true},
+		{name: "middle_out 截短当前问题后不会被拒绝", cfg: Config{SiderTokens: accounts, MaxPromptChars: 500, ContextStrategy: ContextMiddleOut},
+			req: UserRequest{Model: "gpt-4o", Messages: append(history[:2:2], Message{Role: "user", Content: long})}, truncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, tt.cfg)
+			r := httptest.NewRequest("POST", "/v1/count_tokens", nil)
+			if tt.key != nil {
+				r = withIdentity(r, tt.key)
+			}
+			req := tt.req
+			count, _, apiErr := s.countTokens(r, &req)
+			if tt.wantErr != "" {
+				if apiErr == nil || apiErr.Code != tt.wantErr {
+					t.Fatalf("err = %+v, want %s", apiErr, tt.wantErr)
+				}
+				return
+			}
+			if apiErr != nil {
+				t.Fatalf("unexpected err: %+v", apiErr)
+			}
+			got := ""
+			if count.Rejected {
+				got = count.Error.Type
+				if count.Error.Code != nil {
+					got = *count.Error.Code
+				}
+			}
+			if got != tt.rejected {
+				t.Errorf("rejected = %q, want %q", got, tt.rejected)
+			}
+			if count.Truncated != tt.truncated {
+				t.Errorf("truncated = %v, want %v", count.Truncated, tt.truncated)
+			}
+		})
+	}
+}
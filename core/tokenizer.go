@@ -0,0 +1,392 @@
+package core
+
+import (
+	"bufio"
+	"compress/gzip"
+	"container/heap"
+	"embed"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 内置的编码名称
+const (
+	EncodingCL100K = "cl100k_base"
+	EncodingO200K  = "o200k_base"
+)
+
+// 内嵌的词表: core/tokenizer/ 下 gzip 压缩的 tiktoken 词表 <编码名>.tiktoken.gz
+//
+//go:embed tokenizer
+var embeddedVocab embed.FS
+
+// Tokenizer 计算文本的 token 数
+type Tokenizer interface {
+	Encoding() string
+	Count(text string) int
+}
+
+// Tokenizers 按模型选择编码. 词表优先从 TOKENIZER_DIR 读取, 其次使用内嵌词表,
+// 读取失败时退回按分词规则估算的 estimateTokenizer.
+type Tokenizers struct {
+	encodings map[string]Tokenizer
+}
+
+// NewTokenizers 加载 cl100k_base 与 o200k_base 的词表
+func NewTokenizers(cfg Config) *Tokenizers {
+	t := &Tokenizers{encodings: make(map[string]Tokenizer)}
+	for _, name := range []string{EncodingCL100K, EncodingO200K} {
+		tok, source, err := loadBPE(name, cfg.TokenizerDir)
+		if err != nil {
+			fmt.Printf("加载 %s 词表失败, 按分词规则估算 token 数: %v\n", name, err)
+			t.encodings[name] = estimateTokenizer{name: name}
+			continue
+		}
+		fmt.Printf("已加载 %s 词表 (%s, %d 个 token)\n", name, source, len(tok.ranks))
+		t.encodings[name] = tok
+	}
+	return t
+}
+
+// For 返回模型使用的 tokenizer: 注册表中的 encoding 优先, 否则按模型判断
+func (t *Tokenizers) For(m ModelInfo) Tokenizer {
+	if tok, ok := t.encodings[m.Encoding]; ok {
+		return tok
+	}
+	return t.encodings[defaultEncoding(m)]
+}
+
+// defaultEncoding 返回模型缺省使用的编码: gpt-4o 之后的 OpenAI 模型为 o200k_base, 其余为 cl100k_base.
+// Claude / Gemini 等模型的分词器不公开, 以 cl100k_base 近似.
+func defaultEncoding(m ModelInfo) string {
+	for _, prefix := range []string{"gpt-4o", "gpt-4.1", "gpt-4.5", "gpt-5", "o1", "o3", "o4"} {
+		if strings.HasPrefix(m.ID, prefix) || strings.HasPrefix(m.Upstream, prefix) {
+			return EncodingO200K
+		}
+	}
+	return EncodingCL100K
+}
+
+// countMessages 按 OpenAI 的计法统计对话的 token 数: 每条消息额外 3 个, 回复前缀 3 个
+func countMessages(tok Tokenizer, messages []Message) int {
+	total := 3
+	for _, m := range messages {
+		total += 3 + tok.Count(m.Role) + tok.Count(m.Content)
+		if m.Name != "" {
+			total += 1 + tok.Count(m.Name)
+		}
+		for _, call := range m.ToolCalls {
+			total += tok.Count(call.Function.Name) + tok.Count(call.Function.Arguments)
+		}
+	}
+	return total
+}
+
+// loadBPE 读取 tiktoken 格式的词表, 每行为 "base64(token) rank". 依次查找 <编码名>.tiktoken 与 .tiktoken.gz
+func loadBPE(name, dir string) (*bpeTokenizer, string, error) {
+	split, ok := pretokenizers[name]
+	if !ok {
+		return nil, "", fmt.Errorf("不支持的编码 %s", name)
+	}
+	r, source, err := openVocab(name, dir)
+	if err != nil {
+		return nil, "", err
+	}
+	defer r.Close()
+
+	ranks := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		token, rank, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s 格式错误: %v", source, err)
+		}
+		n, err := strconv.Atoi(rank)
+		if err != nil {
+			return nil, "", fmt.Errorf("%s 格式错误: %v", source, err)
+		}
+		ranks[string(raw)] = n
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, "", fmt.Errorf("读取 %s 失败: %v", source, err)
+	}
+	if len(ranks) == 0 {
+		return nil, "", fmt.Errorf("%s 为空", source)
+	}
+	return &bpeTokenizer{name: name, ranks: ranks, split: split}, source, nil
+}
+
+// openVocab 打开词表文件, TOKENIZER_DIR 优先于内嵌词表, .gz 文件按 gzip 解压
+func openVocab(name, dir string) (io.ReadCloser, string, error) {
+	var open []func(file string) (fs.File, string, error)
+	if dir != "" {
+		open = append(open, func(file string) (fs.File, string, error) {
+			path := filepath.Join(dir, file)
+			f, err := os.Open(path)
+			return f, path, err
+		})
+	}
+	open = append(open, func(file string) (fs.File, string, error) {
+		f, err := embeddedVocab.Open("tokenizer/" + file)
+		return f, "内嵌 " + file, err
+	})
+
+	for _, o := range open {
+		for _, file := range []string{name + ".tiktoken", name + ".tiktoken.gz"} {
+			f, source, err := o(file)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return nil, "", err
+			}
+			if !strings.HasSuffix(file, ".gz") {
+				return f, source, nil
+			}
+			gz, err := gzip.NewReader(f)
+			if err != nil {
+				f.Close()
+				return nil, "", fmt.Errorf("解压 %s 失败: %v", source, err)
+			}
+			return gzipFile{gz, f}, source, nil
+		}
+	}
+	return nil, "", fmt.Errorf("未找到 %s.tiktoken", name)
+}
+
+// gzipFile 关闭时同时关闭解压器与底层文件
+type gzipFile struct {
+	*gzip.Reader
+	file io.Closer
+}
+
+func (g gzipFile) Close() error {
+	g.Reader.Close()
+	return g.file.Close()
+}
+
+// bpeTokenizer 是 tiktoken 的字节级 BPE: 先按编码的正则切分, 再在每段内按 rank 从低到高合并相邻字节
+type bpeTokenizer struct {
+	name  string
+	ranks map[string]int
+	split *pretokenizer
+}
+
+func (t *bpeTokenizer) Encoding() string {
+	return t.name
+}
+
+func (t *bpeTokenizer) Count(text string) int {
+	total := 0
+	t.split.each(text, func(piece string) {
+		if _, ok := t.ranks[piece]; ok {
+			total++
+			return
+		}
+		total += len(t.merge(piece))
+	})
+	return total
+}
+
+// encode 返回文本的 token id
+func (t *bpeTokenizer) encode(text string) []int {
+	var ids []int
+	t.split.each(text, func(piece string) {
+		if rank, ok := t.ranks[piece]; ok {
+			ids = append(ids, rank)
+			return
+		}
+		starts := t.merge(piece)
+		for i, start := range starts {
+			end := len(piece)
+			if i+1 < len(starts) {
+				end = starts[i+1]
+			}
+			ids = append(ids, t.ranks[piece[start:end]])
+		}
+	})
+	return ids
+}
+
+// merge 返回一段文本合并后各 token 的起始字节.
+// 每次合并 rank 最小的相邻一对 (相同时取靠前的), 候选放在堆中, 长文本也是 O(n log n)
+func (t *bpeTokenizer) merge(piece string) []int {
+	n := len(piece)
+	// next[i] / prev[i] 为起始于 i 的 token 的后一个 / 前一个 token 的起始字节, 结尾为 n
+	next := make([]int, n)
+	prev := make([]int, n)
+	for i := 0; i < n; i++ {
+		next[i], prev[i] = i+1, i-1
+	}
+	alive := make([]bool, n)
+	for i := range alive {
+		alive[i] = true
+	}
+
+	pairs := &mergeHeap{}
+	push := func(start int) {
+		if start < 0 || next[start] >= n {
+			return
+		}
+		end := next[next[start]]
+		if rank, ok := t.ranks[piece[start:end]]; ok {
+			heap.Push(pairs, mergePair{rank: rank, start: start, end: end})
+		}
+	}
+	for i := 0; i < n; i++ {
+		push(i)
+	}
+
+	for pairs.Len() > 0 {
+		p := heap.Pop(pairs).(mergePair)
+		// 跳过已失效的候选: 起点已被合并, 或这一对的范围已经变化
+		mid := next[p.start]
+		if !alive[p.start] || mid >= n || next[mid] != p.end {
+			continue
+		}
+		alive[mid] = false
+		next[p.start] = next[mid]
+		if next[mid] < n {
+			prev[next[mid]] = p.start
+		}
+		push(prev[p.start])
+		push(p.start)
+	}
+
+	var starts []int
+	for i := 0; i < n; i = next[i] {
+		starts = append(starts, i)
+	}
+	return starts
+}
+
+type mergePair struct {
+	rank, start, end int
+}
+
+// mergeHeap 按 rank 排序, rank 相同时靠前的优先
+type mergeHeap []mergePair
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	if h[i].rank != h[j].rank {
+		return h[i].rank < h[j].rank
+	}
+	return h[i].start < h[j].start
+}
+func (h mergeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergePair)) }
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// estimateTokenizer 在没有词表时使用: 按 cl100k_base 的规则切分后, 英文字母与数字约 6 个 1 个 token
+// (1-3 位的数字段为 1 个), 中日韩字符与标点每个 1 个, 纯空白段 1 个
+type estimateTokenizer struct {
+	name string
+}
+
+func (t estimateTokenizer) Encoding() string {
+	return t.name + " (estimate)"
+}
+
+func (t estimateTokenizer) Count(text string) int {
+	total := 0
+	pretokenizers[EncodingCL100K].each(text, func(piece string) {
+		ascii, other := 0, 0
+		for _, r := range piece {
+			switch {
+			case unicode.IsSpace(r):
+			case r < utf8.RuneSelf && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+				ascii++
+			default:
+				other++
+			}
+		}
+		n := other + (ascii+5)/6
+		if n == 0 {
+			n = 1
+		}
+		total += n
+	})
+	return total
+}
+
+// 各编码的切分正则, 与 tiktoken 相同. Go 的 \s 只含 ASCII 空白, 这里写成与 tiktoken 一致的 Unicode 空白;
+// Go 不支持的 \s+(?!\S) 由 pretokenizer.each 处理
+const (
+	whitespace    = `\t\n\v\f\r\x{85}\p{Z}`
+	contractions  = `(?i:'s|'t|'re|'ve|'m|'ll|'d)`
+	cl100kPattern = contractions + `|[^\r\n\p{L}\p{N}]?\p{L}+|\p{N}{1,3}| ?[^` + whitespace + `\p{L}\p{N}]+[\r\n]*|[` + whitespace + `]*[\r\n]+|[` + whitespace + `]+`
+	o200kUpper    = `[\p{Lu}\p{Lt}\p{Lm}\p{Lo}\p{M}]`
+	o200kLower    = `[\p{Ll}\p{Lm}\p{Lo}\p{M}]`
+	o200kPattern  = `[^\r\n\p{L}\p{N}]?` + o200kUpper + `*` + o200kLower + `+` + contractions + `?` +
+		`|[^\r\n\p{L}\p{N}]?` + o200kUpper + `+` + o200kLower + `*` + contractions + `?` +
+		`|\p{N}{1,3}| ?[^` + whitespace + `\p{L}\p{N}]+[\r\n/]*|[` + whitespace + `]*[\r\n]+|[` + whitespace + `]+`
+)
+
+var pretokenizers = map[string]*pretokenizer{
+	EncodingCL100K: newPretokenizer(cl100kPattern),
+	EncodingO200K:  newPretokenizer(o200kPattern),
+}
+
+const pretokenizeWindow = 256
+
+// pretokenizer 将文本切分为 BPE 合并前的片段
+type pretokenizer struct {
+	re    *regexp.Regexp
+	space *regexp.Regexp
+}
+
+func newPretokenizer(pattern string) *pretokenizer {
+	return &pretokenizer{
+		re:    regexp.MustCompile(`^(?:` + pattern + `)`),
+		space: regexp.MustCompile(`^[` + whitespace + `]+$`),
+	}
+}
+
+func (p *pretokenizer) each(text string, fn func(piece string)) {
+	for pos := 0; pos < len(text); {
+		// 只在一小段窗口内匹配, 正则引擎处理短输入更快; 匹配到窗口末尾时扩大窗口重试
+		var loc []int
+		for window := pretokenizeWindow; ; window *= 2 {
+			if pos+window >= len(text) {
+				loc = p.re.FindStringIndex(text[pos:])
+				break
+			}
+			loc = p.re.FindStringIndex(text[pos : pos+window])
+			if loc == nil || loc[1] < window-utf8.UTFMax {
+				break
+			}
+		}
+		end := pos + 1
+		if loc != nil && loc[1] > 0 {
+			end = pos + loc[1]
+		}
+		// \s+(?!\S): 后面还有文本的空白串 (不含换行) 留下最后一个空白, 与下一段合并
+		if piece := text[pos:end]; end < len(text) && !strings.ContainsAny(piece, "\r\n") && p.space.MatchString(piece) {
+			if _, size := utf8.DecodeLastRuneInString(piece); size < len(piece) {
+				end -= size
+			}
+		}
+		fn(text[pos:end])
+		pos = end
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testBPE = make(map[string]*bpeTokenizer)
+
+func loadTestBPE(t *testing.T, name string) *bpeTokenizer {
+	t.Helper()
+	if tok, ok := testBPE[name]; ok {
+		return tok
+	}
+	tok, _, err := loadBPE(name, "")
+	if err != nil {
+		t.Fatalf("加载内嵌词表 %s 失败: %v", name, err)
+	}
+	testBPE[name] = tok
+	return tok
+}
+
+// 期望值来自 tiktoken 的同名编码
+func TestBPEEncode(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     []int
+	}{
+		{EncodingCL100K, "hello world", []int{15339, 1917}},
+		{EncodingCL100K, "Hello, World!", []int{9906, 11, 4435, 0}},
+		{EncodingCL100K, "I'm here. You're there. They've gone, we'll see, he'd know. IT'S DON'T",
+			[]int{40, 2846, 1618, 13, 1472, 2351, 1070, 13, 2435, 3077, 8208, 11, 584, 3358, 1518, 11, 568, 4265, 1440, 13, 8871, 13575, 45373, 17773}},
+		{EncodingCL100K, "line1\nline2\r\nline3\n\n\nline4", []int{1074, 16, 198, 1074, 17, 319, 1074, 18, 1432, 1074, 19}},
+		{EncodingCL100K, "  \n  \n  x", []int{31879, 220, 865}},
+		{EncodingCL100K, "12345678901234567890", []int{4513, 10961, 16474, 11531, 12901, 17458, 1954}},
+		{EncodingCL100K, "你好，世界！这是一个测试。", []int{57668, 53901, 3922, 3574, 244, 98220, 6447, 44388, 21043, 48044, 82805, 1811}},
+		{EncodingCL100K, "CamelCaseWordsAndHTTPServer XMLHttpRequest iPhone", []int{26479, 301, 4301, 24390, 3112, 9412, 5592, 46938, 12443}},
+		{EncodingCL100K, "\u00a0nbsp\u3000ideographic\u2003em space", []int{4194, 5792, 23249, 95107, 378, 225, 336, 3634}},
+		{EncodingCL100K, "???!!!...;;;", []int{34115, 12340, 1131, 37428}},
+
+		{EncodingO200K, "hello world", []int{24912, 2375}},
+		{EncodingO200K, "Hello, World!", []int{13225, 11, 5922, 0}},
+		{EncodingO200K, "I'm here. You're there. They've gone, we'll see, he'd know. IT'S DON'T",
+			[]int{15390, 2105, 13, 48156, 1354, 13, 152758, 12299, 11, 22782, 1921, 11, 71619, 1761, 13, 8734, 31233, 153384}},
+		{EncodingO200K, "line1\nline2\r\nline3\n\n\nline4", []int{1137, 16, 198, 1137, 17, 370, 1137, 18, 2499, 1137, 19}},
+		{EncodingO200K, "  \n  \n  x", []int{59384, 220, 1215}},
+		{EncodingO200K, "12345678901234567890", []int{7633, 19354, 29338, 19267, 22901, 30833, 2744}},
+		{EncodingO200K, "你好，世界！这是一个测试。", []int{177519, 979, 28428, 3393, 135398, 22912, 82843, 788}},
+		{EncodingO200K, "CamelCaseWordsAndHTTPServer XMLHttpRequest iPhone", []int{137910, 6187, 27321, 3436, 17893, 6444, 100497, 2303, 575, 7081}},
+		{EncodingO200K, "\u00a0nbsp\u3000ideographic\u2003em space", []int{5310, 9431, 1397, 617, 19045, 33203, 347, 4918}},
+		{EncodingO200K, "???!!!...;;;", []int{33110, 10880, 1008, 99978}},
+	}
+	toks := map[string]*bpeTokenizer{
+		EncodingCL100K: loadTestBPE(t, EncodingCL100K),
+		EncodingO200K:  loadTestBPE(t, EncodingO200K),
+	}
+	for _, tt := range tests {
+		tok := toks[tt.encoding]
+		if got := tok.encode(tt.text); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s encode(%q) = %v, want %v", tt.encoding, tt.text, got, tt.want)
+		}
+		if got := tok.Count(tt.text); got != len(tt.want) {
+			t.Errorf("%s Count(%q) = %d, want %d", tt.encoding, tt.text, got, len(tt.want))
+		}
+	}
+}
+
+// 很长的单个片段按堆合并, 结果与 tiktoken 一致
+func TestBPELongPiece(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     int
+	}{
+		{EncodingCL100K, strings.Repeat("x", 3000), 375},
+		{EncodingCL100K, strings.Repeat("ab", 2000), 2000},
+		{EncodingO200K, strings.Repeat("x", 3000), 375},
+		{EncodingO200K, strings.Repeat("ab", 2000), 1000},
+	}
+	for _, tt := range tests {
+		if got := loadTestBPE(t, tt.encoding).Count(tt.text); got != tt.want {
+			t.Errorf("%s Count(%q... %d 字节) = %d, want %d", tt.encoding, tt.text[:4], len(tt.text), got, tt.want)
+		}
+	}
+}
+
+func TestPretokenizer(t *testing.T) {
+	tests := []struct {
+		encoding string
+		text     string
+		want     []string
+	}{
+		// \s+(?!\S): 空白串留下最后一个空白给后面的单词
+		{EncodingCL100K, "a   b", []string{"a", "  ", " b"}},
+		{EncodingCL100K, "a   ", []string{"a", "   "}},
+		{EncodingCL100K, "a \n\n b", []string{"a", " \n\n", " b"}},
+		{EncodingCL100K, "don't", []string{"don", "'t"}},
+		{EncodingCL100K, "12345", []string{"123", "45"}},
+		{EncodingO200K, "don't", []string{"don't"}},
+		{EncodingO200K, "HTTPServer", []string{"HTTPServer"}},
+		{EncodingO200K, "a/b\n/c", []string{"a", "/b", "\n", "/c"}},
+	}
+	for _, tt := range tests {
+		var got []string
+		pretokenizers[tt.encoding].each(tt.text, func(piece string) { got = append(got, piece) })
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s split(%q) = %q, want %q", tt.encoding, tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+)
+
+// Sider 不支持自定义函数调用, 这里通过 prompt 约定模拟:
+// 在 system 中描述可用函数与调用格式, 模型需要调用时输出 <tool_calls>[...]</tool_calls>,
+// 再将其解析为 OpenAI 的 tool_calls. 函数结果 (role: tool) 在下一轮以文本形式放入历史.
+const (
+	toolCallsOpen  = "<tool_calls>"
+	toolCallsClose = "</tool_calls>"
+)
+
+// 解析失败时的处理方式 (TOOL_PARSE_FALLBACK)
+const (
+	ToolFallbackText  = "text"  // 将模型的原始输出作为普通回复返回
+	ToolFallbackError = "error" // 返回 502 tool_parse_error
+)
+
+// OpenAI tools 中的一项, 目前只有 function 类型
+type OpenAITool struct {
+	Type     string         `json:"type"`
+	Function OpenAIFunction `json:"function"`
+}
+
+type OpenAIFunction struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"` // JSON Schema
+}
+
+// OpenAI 回复中的函数调用, 流式分块中带 Index
+type OpenAIToolCall struct {
+	Index    *int               `json:"index,omitempty"`
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"` // JSON 字符串
+}
+
+// toolCalling 是一次请求的函数调用模拟设置
+type toolCalling struct {
+	tools    map[string]bool
+	required string // tool_choice 要求必须调用的函数名, "*" 表示任一函数, 为空表示可以不调用
+	fallback string
+}
+
+// prepareTools 校验 tools / tool_choice, 将调用协议写入 system 消息, 并把历史中的函数调用与结果转为文本.
+// 未提供 tools 或 tool_choice 为 none 时返回 nil, 只转换历史.
+func (s *Server) prepareTools(userReq *UserRequest) (*toolCalling, *apiError) {
+	userReq.Messages = renderToolMessages(userReq.Messages)
+	if len(userReq.Tools) == 0 {
+		return nil, nil
+	}
+
+	tc := &toolCalling{tools: make(map[string]bool), fallback: s.cfg.ToolFallback}
+	for _, t := range userReq.Tools {
+		if t.Type != "function" || t.Function.Name == "" {
+			return nil, invalidTools("tools 中的每一项都必须是带 name 的 function")
+		}
+		tc.tools[t.Function.Name] = true
+	}
+
+	if len(userReq.ToolChoice) > 0 && string(userReq.ToolChoice) != "null" {
+		var choice string
+		var named struct {
+			Function struct {
+				Name string `json:"name"`
+			} `json:"function"`
+		}
+		switch {
+		case json.Unmarshal(userReq.ToolChoice, &choice) == nil:
+			switch choice {
+			case "none":
+				return nil, nil
+			case "required":
+				tc.required = "*"
+			case "auto":
+			default:
+				return nil, invalidTools("tool_choice 只支持 none / auto / required 或指定函数")
+			}
+		case json.Unmarshal(userReq.ToolChoice, &named) == nil && named.Function.Name != "":
+			if !tc.tools[named.Function.Name] {
+				return nil, invalidTools(fmt.Sprintf("tool_choice 指定的函数 %s 不在 tools 中", named.Function.Name))
+			}
+			tc.required = named.Function.Name
+		default:
+			return nil, invalidTools("无法解析 tool_choice")
+		}
+	}
+
+	system := Message{Role: "system", Content: toolPrompt(userReq.Tools, tc.required)}
+	userReq.Messages = append([]Message{system}, userReq.Messages...)
+	return tc, nil
+}
+
+func invalidTools(message string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "invalid_tools", Message: message}
+}
+
+// toolPrompt 生成描述可用函数与调用格式的 system 提示
+func toolPrompt(tools []OpenAITool, required string) string {
+	var b strings.Builder
+	b.WriteString("[Tools]\nYou can call the following functions. Parameters are described with JSON Schema:\n")
+	for _, t := range tools {
+		line, _ := json.Marshal(t.Function)
+		b.Write(line)
+		b.WriteString("\n")
+	}
+	b.WriteString("\nTo call functions, reply with this block and nothing after it:\n")
+	b.WriteString(toolCallsOpen + "\n[{\"name\": \"<function name>\", \"arguments\": {<arguments as a JSON object>}}]\n" + toolCallsClose + "\n")
+	b.WriteString("Several functions can be called at once by adding more objects to the array. ")
+	b.WriteString("Function results are given in later messages starting with [Tool Result]. ")
+	switch required {
+	case "":
+		b.WriteString("If no function is needed, answer normally without the block.")
+	case "*":
+		b.WriteString("You MUST call at least one function in this reply.")
+	default:
+		b.WriteString(fmt.Sprintf("You MUST call the function %q in this reply.", required))
+	}
+	return b.String()
+}
+
+// renderToolMessages 将 assistant 的 tool_calls 与 role: tool 的结果转为模型可读的文本
+func renderToolMessages(messages []Message) []Message {
+	type renderedCall struct {
+		Name      string      `json:"name"`
+		Arguments interface{} `json:"arguments"`
+	}
+	names := make(map[string]string) // tool_call_id -> 函数名
+	rendered := make([]Message, len(messages))
+	for i, m := range messages {
+		if m.Role == "assistant" && len(m.ToolCalls) > 0 {
+			calls := make([]renderedCall, len(m.ToolCalls))
+			for j, call := range m.ToolCalls {
+				names[call.ID] = call.Function.Name
+				calls[j] = renderedCall{Name: call.Function.Name, Arguments: call.Function.Arguments}
+				if json.Valid([]byte(call.Function.Arguments)) {
+					calls[j].Arguments = json.RawMessage(call.Function.Arguments)
+				}
+			}
+			block, _ := json.Marshal(calls)
+			m.Content = strings.TrimSpace(m.Content + "\n" + toolCallsOpen + "\n" + string(block) + "\n" + toolCallsClose)
+		}
+		if m.Role == "tool" {
+			name := m.Name
+			if name == "" {
+				name = names[m.ToolCallID]
+			}
+			m.Content = fmt.Sprintf("[Tool Result] %s (%s):\n%s", name, m.ToolCallID, m.Content)
+		}
+		rendered[i] = m
+	}
+	return rendered
+}
+
+// parse 从完整回复中解析函数调用, 返回调用之前的正文与调用列表.
+// 格式错误、调用了未定义的函数或未按 tool_choice 调用时返回错误.
+func (tc *toolCalling) parse(text string) (string, []OpenAIToolCall, error) {
+	start := strings.Index(text, toolCallsOpen)
+	if start < 0 {
+		if tc.required != "" {
+			return text, nil, fmt.Errorf("模型未按 tool_choice 调用函数")
+		}
+		return text, nil, nil
+	}
+	content := strings.TrimSpace(text[:start])
+	block := text[start+len(toolCallsOpen):]
+	if end := strings.Index(block, toolCallsClose); end >= 0 {
+		block = block[:end]
+	}
+	block = strings.TrimSpace(block)
+	block = strings.TrimPrefix(block, "```json")
+	block = strings.TrimSpace(strings.Trim(block, "`"))
+
+	type rawCall struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	var raw []rawCall
+	if err := json.Unmarshal([]byte(block), &raw); err != nil {
+		var single rawCall
+		if json.Unmarshal([]byte(block), &single) != nil {
+			return content, nil, fmt.Errorf("函数调用不是合法的 JSON: %v", err)
+		}
+		raw = []rawCall{single}
+	}
+	if len(raw) == 0 {
+		return content, nil, fmt.Errorf("函数调用列表为空")
+	}
+
+	calls := make([]OpenAIToolCall, 0, len(raw))
+	called := tc.required == ""
+	for _, r := range raw {
+		if !tc.tools[r.Name] {
+			return content, nil, fmt.Errorf("模型调用了未定义的函数 %q", r.Name)
+		}
+		called = called || tc.required == "*" || r.Name == tc.required
+		args, err := toolArguments(r.Arguments)
+		if err != nil {
+			return content, nil, fmt.Errorf("函数 %s 的参数无效: %v", r.Name, err)
+		}
+		calls = append(calls, OpenAIToolCall{
+			ID:       "call_" + randomHex(12),
+			Type:     "function",
+			Function: OpenAIFunctionCall{Name: r.Name, Arguments: args},
+		})
+	}
+	if !called {
+		return content, nil, fmt.Errorf("模型未调用 tool_choice 指定的函数 %s", tc.required)
+	}
+	return content, calls, nil
+}
+
+// toolArguments 将参数规范为 JSON 对象字符串, 兼容模型把参数写成字符串的情况
+func toolArguments(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "{}", nil
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		raw = json.RawMessage(text)
+	}
+	var args map[string]interface{}
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := json.Compact(&buf, raw); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// toolParseError 是 TOOL_PARSE_FALLBACK=error 时返回的错误
+func toolParseError(err error) *apiError {
+	return &apiError{Status: http.StatusBadGateway, Type: "upstream_error", Code: "tool_parse_error",
+		Message: "解析模型的函数调用失败: " + err.Error()}
+}
+
+// toolStream 在流式输出时扣住函数调用块: 普通文本照常输出,
+// 可能是 <tool_calls> 开头的部分及其之前的空白暂不输出, 进入调用块后全部缓存到结束时统一解析
+type toolStream struct {
+	held    string
+	calling bool
+}
+
+// feed 返回可以立即输出的文本
+func (ts *toolStream) feed(text string) string {
+	if ts.calling {
+		ts.held += text
+		return ""
+	}
+	buf := ts.held + text
+	if i := strings.Index(buf, toolCallsOpen); i >= 0 {
+		// 调用块之前的空白丢弃, 不在 tool_calls 之前输出只有空白的分块
+		ts.calling = true
+		ts.held = buf[i:]
+		return strings.TrimRightFunc(buf[:i], unicode.IsSpace)
+	}
+	// 末尾的空白要等到后面是普通文本时才输出
+	cut := len(strings.TrimRightFunc(buf[:len(buf)-partialPrefix(buf, toolCallsOpen)], unicode.IsSpace))
+	ts.held = buf[cut:]
+	return buf[:cut]
+}
+
+// partialPrefix 返回 text 末尾与 marker 开头重合的长度
+func partialPrefix(text, marker string) int {
+	for n := len(marker) - 1; n > 0; n-- {
+		if strings.HasSuffix(text, marker[:n]) {
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestToolCallingParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		required string
+		text     string
+		content  string
+		calls    []OpenAIFunctionCall
+		wantErr  string
+	}{
+		{name: "普通回复", text: "Hello", content: "Hello"},
+		{
+			name:    "调用之前有正文",
+			text:    "Let me check.\n<tool_calls>\n[{\"name\": \"get_weather\", \"arguments\": {\"city\": \"Paris\"}}]\n</tool_calls>",
+			content: "Let me check.",
+			calls:   []OpenAIFunctionCall{{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		},
+		{
+			name: "多个调用",
+			text: `<tool_calls>[{"name": "get_weather", "arguments": {"city": "Paris"}}, {"name": "get_time", "arguments": {}}]</tool_calls>`,
+			calls: []OpenAIFunctionCall{
+				{Name: "get_weather", Arguments: `{"city":"Paris"}`},
+				{Name: "get_time", Arguments: `{}`},
+			},
+		},
+		{
+			name:  "单个对象, 参数写成字符串, 缺少结束标记",
+			text:  "<tool_calls>\n{\"name\": \"get_weather\", \"arguments\": \"{\\\"city\\\": \\\"Paris\\\"}\"}",
+			calls: []OpenAIFunctionCall{{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		},
+		{
+			name:  "markdown 代码块, 参数缺省",
+			text:  "<tool_calls>\n```json\n[{\"name\": \"get_time\"}]\n```\n</tool_calls>",
+			calls: []OpenAIFunctionCall{{Name: "get_time", Arguments: `{}`}},
+		},
+		{name: "不是 JSON", text: "<tool_calls>get_weather(Paris)</tool_calls>", wantErr: "不是合法的 JSON"},
+		{name: "空列表", text: "<tool_calls>[]</tool_calls>", wantErr: "列表为空"},
+		{name: "未定义的函数", text: `<tool_calls>[{"name": "rm_rf"}]</tool_calls>`, wantErr: "未定义的函数"},
+		{name: "参数不是对象", text: `<tool_calls>[{"name": "get_time", "arguments": [1]}]</tool_calls>`, wantErr: "参数无效"},
+		{name: "required 但未调用", required: "*", text: "Hello", content: "Hello", wantErr: "未按 tool_choice"},
+		{
+			name:     "指定函数但调用了别的",
+			required: "get_weather",
+			text:     `<tool_calls>[{"name": "get_time"}]</tool_calls>`,
+			wantErr:  "未调用 tool_choice 指定的函数",
+		},
+		{
+			name:     "指定函数",
+			required: "get_weather",
+			text:     `<tool_calls>[{"name": "get_weather", "arguments": {"city": "Paris"}}]</tool_calls>`,
+			calls:    []OpenAIFunctionCall{{Name: "get_weather", Arguments: `{"city":"Paris"}`}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tc := &toolCalling{tools: map[string]bool{"get_weather": true, "get_time": true}, required: tt.required}
+			content, calls, err := tc.parse(tt.text)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("err = %v", err)
+			}
+			if content != tt.content {
+				t.Errorf("content = %q, want %q", content, tt.content)
+			}
+			var got []OpenAIFunctionCall
+			for _, call := range calls {
+				if call.Type != "function" || !strings.HasPrefix(call.ID, "call_") {
+					t.Errorf("call = %+v, want type function and id call_*", call)
+				}
+				got = append(got, call.Function)
+			}
+			if !reflect.DeepEqual(got, tt.calls) {
+				t.Errorf("calls = %+v, want %+v", got, tt.calls)
+			}
+		})
+	}
+}
+
+func TestToolStreamFeed(t *testing.T) {
+	tests := []struct {
+		name    string
+		chunks  []string
+		emitted []string // 每个分块之后立即输出的文本
+		held    string   // 结束时扣住的文本
+		calling bool
+	}{
+		{
+			name:    "普通文本照常输出",
+			chunks:  []string{"Hello", " world"},
+			emitted: []string{"Hello", " world"},
+		},
+		{
+			name:    "文本中的 < 不是标记",
+			chunks:  []string{"a <", "b"},
+			emitted: []string{"a", " <b"},
+		},
+		{
+			name:    "标记被拆到多个分块",
+			chunks:  []string{"Sure.<tool", "_cal", "ls>[{\"name\"", ": \"f\"}]</tool_calls>"},
+			emitted: []string{"Sure.", "", "", ""},
+			held:    "<tool_calls>[{\"name\": \"f\"}]</tool_calls>",
+			calling: true,
+		},
+		{
+			name:    "标记之前的空白不输出",
+			chunks:  []string{"Sure.", "\n\n", "<tool_calls>[]"},
+			emitted: []string{"Sure.", "", ""},
+			held:    "<tool_calls>[]",
+			calling: true,
+		},
+		{
+			name:    "空白与拆开的标记在同一分块",
+			chunks:  []string{"Sure. <tool", "_calls>"},
+			emitted: []string{"Sure.", ""},
+			held:    "<tool_calls>",
+			calling: true,
+		},
+		{
+			name:    "扣住的空白在后面是普通文本时输出",
+			chunks:  []string{"a ", "<t", "ext>"},
+			emitted: []string{"a", "", " <text>"},
+		},
+		{
+			name:    "结束时扣住末尾空白",
+			chunks:  []string{"done \n"},
+			emitted: []string{"done"},
+			held:    " \n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var ts toolStream
+			var emitted []string
+			for _, chunk := range tt.chunks {
+				emitted = append(emitted, ts.feed(chunk))
+			}
+			if !reflect.DeepEqual(emitted, tt.emitted) {
+				t.Errorf("emitted = %q, want %q", emitted, tt.emitted)
+			}
+			if ts.held != tt.held || ts.calling != tt.calling {
+				t.Errorf("held = %q calling = %v, want %q %v", ts.held, ts.calling, tt.held, tt.calling)
+			}
+		})
+	}
+}
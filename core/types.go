@@ -0,0 +1,171 @@
+package core
+
+import "encoding/json"
+
+// 用户请求的结构
+type UserRequest struct {
+	Messages        []Message `json:"messages"`
+	Model           string    `json:"model"`
+	Stream          bool      `json:"stream"`
+	MaxTokens       int       `json:"max_tokens"`
+	ReasoningEffort string    `json:"reasoning_effort"` // 非空时覆盖按模型名判断的 think 模式, "none" 表示关闭
+	StreamOptions   *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+	Tools          []OpenAITool    `json:"tools"`           // 通过 prompt 模拟的函数调用, 见 tools.go
+	ToolChoice     json.RawMessage `json:"tool_choice"`     // none / auto / required 或 {"type":"function","function":{"name":...}}
+	ResponseFormat *ResponseFormat `json:"response_format"` // json_object / json_schema, 见 structured.go
+
+	siderTools []string // 非空时替换默认模板中的上游内置工具, 如图片生成只启用 text_to_image
+	internal   bool     // 服务内部发起或单轮的请求 (如历史摘要、图片生成): 不复用/保存会话, 不做上下文管理
+	charged    bool     // 同一客户端请求中的后续上游请求 (如多张图片、JSON 修正): 已计入限流、配额与请求数, 不再重复计入
+}
+
+type Message struct {
+	Role             string           `json:"role"`
+	Content          string           `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"` // 仅响应中使用
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string           `json:"tool_call_id,omitempty"` // role: tool 时对应的调用
+	Name             string           `json:"name,omitempty"`
+
+	Images []string `json:"-"` // 多段 content 中的图片地址 (http(s) 或 data URL), 目前只用于返回 vision_not_supported
+}
+
+var defaultJsonTemplate = []byte(`{
+	"app_name": "ChitChat_Edge_Ext",
+	"app_version": "4.40.0",
+	"tz_name": "Asia/Shanghai",
+	"cid": "",
+	"search": false,
+	"auto_search": false,
+	"filter_search_history": false,
+	"from": "chat",
+	"group_id": "default",
+	"chat_models": [],
+	"files": [],
+	"prompt_templates": [
+		{"key": "artifacts", "attributes": {"lang": "original"}},
+		{"key": "thinking_mode", "attributes": {}}
+	],
+	"tools": {
+		"auto": ["search", "text_to_image", "data_analysis"]
+	},
+	"think_mode": {"enable": false},
+	"extra_info": {
+		"origin_url": "chrome-extension://dhoenijjpgpeimemopealfcbiecgceod/standalone.html?from=sidebar",
+		"origin_title": "Sider"
+	},
+	"branch": true
+}`)
+
+// Sider响应结构, 即 SSE 每行 data: 的原始内容 (解码后的事件见 events.go)
+type SiderResponse struct {
+	Code int             `json:"code"`
+	Msg  string          `json:"msg"`
+	Data *SiderEventData `json:"data"`
+}
+
+type SiderEventData struct {
+	Type             string             `json:"type"`
+	Text             string             `json:"text"`
+	ChatModel        string             `json:"chat_model"`
+	MessageStart     *SiderMessageStart `json:"message_start"`
+	ReasoningContent json.RawMessage    `json:"reasoning_content"` // {"text":"..."} 或字符串
+	ToolCall         *SiderToolCall     `json:"tool_call"`
+	File             *SiderFile         `json:"file"`
+	CreditInfo       json.RawMessage    `json:"credit_info"`
+}
+
+// message_start 事件携带的会话信息
+type SiderMessageStart struct {
+	CID                string `json:"cid"`
+	AssistantMessageID string `json:"assistant_message_id"`
+	ParentMessageID    string `json:"parent_message_id"`
+}
+
+// tool_call 事件, 上游内置工具 (搜索/画图等) 的执行状态
+type SiderToolCall struct {
+	Name   string `json:"name"`
+	Status string `json:"status"` // start / processing / finish
+}
+
+// file 事件, 目前只有图片
+type SiderFile struct {
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// OpenAI响应结构
+type OpenAIResponse struct {
+	ID      string         `json:"id"`
+	Object  string         `json:"object"`
+	Created int64          `json:"created"`
+	Model   string         `json:"model"`
+	Choices []OpenAIChoice `json:"choices"`
+	Usage   OpenAIUsage    `json:"usage"`
+}
+
+type OpenAIChoice struct {
+	Message      Message `json:"message"`
+	FinishReason string  `json:"finish_reason"`
+	Index        int     `json:"index"`
+}
+
+type OpenAIUsage struct {
+	PromptTokens     int             `json:"prompt_tokens"`
+	CompletionTokens int             `json:"completion_tokens"`
+	TotalTokens      int             `json:"total_tokens"`
+	CreditInfo       json.RawMessage `json:"credit_info,omitempty"` // 上游 credit_info 事件原样透传
+}
+
+// OpenAI流式响应结构
+type OpenAIStreamResponse struct {
+	ID      string               `json:"id"`
+	Object  string               `json:"object"`
+	Created int64                `json:"created"`
+	Model   string               `json:"model"`
+	Choices []OpenAIStreamChoice `json:"choices"`
+	Usage   *OpenAIUsage         `json:"usage,omitempty"` // 仅 stream_options.include_usage 时的最后一个分块
+}
+
+type OpenAIStreamChoice struct {
+	Delta        OpenAIDelta `json:"delta"`
+	FinishReason *string     `json:"finish_reason"` // 结束前为 null
+	Index        int         `json:"index"`
+}
+
+type OpenAIDelta struct {
+	Role             string           `json:"role,omitempty"`
+	Content          string           `json:"content,omitempty"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// OpenAI 错误响应结构
+type OpenAIError struct {
+	Error OpenAIErrorDetail `json:"error"`
+}
+
+type OpenAIErrorDetail struct {
+	Message string  `json:"message"`
+	Type    string  `json:"type"`
+	Code    *string `json:"code"`
+}
+
+type Model struct {
+	ID            string   `json:"id"`
+	Object        string   `json:"object"`
+	OwnedBy       string   `json:"owned_by"`
+	Permission    []string `json:"permission"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+	ContextLength int      `json:"context_length,omitempty"`
+	Aliases       []string `json:"aliases,omitempty"`
+}
+
+type ModelListResponse struct {
+	Object string  `json:"object"`
+	Data   []Model `json:"data"`
+}
@@ -0,0 +1,91 @@
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// OpenAI 多段 content 中的一段: text 或 image_url
+type OpenAIContentPart struct {
+	Type     string          `json:"type"`
+	Text     string          `json:"text"`
+	ImageURL json.RawMessage `json:"image_url"` // {"url": "...", "detail": "..."} 或字符串
+}
+
+// UnmarshalJSON 兼容字符串、null 与多段数组形式的 content:
+// 文本段拼接为 Content, 图片段的地址放入 Images
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Role             string           `json:"role"`
+		Content          json.RawMessage  `json:"content"`
+		ReasoningContent string           `json:"reasoning_content"`
+		ToolCalls        []OpenAIToolCall `json:"tool_calls"`
+		ToolCallID       string           `json:"tool_call_id"`
+		Name             string           `json:"name"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*m = Message{Role: raw.Role, ReasoningContent: raw.ReasoningContent, ToolCalls: raw.ToolCalls, ToolCallID: raw.ToolCallID, Name: raw.Name}
+	if len(raw.Content) == 0 || string(raw.Content) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(raw.Content, &m.Content); err == nil {
+		return nil
+	}
+
+	var parts []OpenAIContentPart
+	if err := json.Unmarshal(raw.Content, &parts); err != nil {
+		return fmt.Errorf("content 必须是字符串或数组: %v", err)
+	}
+	var texts []string
+	for _, p := range parts {
+		switch p.Type {
+		case "text", "input_text":
+			texts = append(texts, p.Text)
+		case "image_url":
+			var image struct {
+				URL string `json:"url"`
+			}
+			if err := json.Unmarshal(p.ImageURL, &image); err != nil {
+				json.Unmarshal(p.ImageURL, &image.URL)
+			}
+			if image.URL != "" {
+				m.Images = append(m.Images, image.URL)
+			}
+		}
+	}
+	m.Content = strings.Join(texts, "\n")
+	return nil
+}
+
+// dataURL 将 base64 编码的图片数据转换为 data URL
+func dataURL(mimeType, data string) string {
+	if mimeType == "" {
+		mimeType = "image/png"
+	}
+	return "data:" + mimeType + ";base64," + data
+}
+
+// hasImages 判断消息中是否包含图片
+func hasImages(messages []Message) bool {
+	for _, m := range messages {
+		if len(m.Images) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// visionNotSupported 是收到图片输入时的错误, 与所选模型无关.
+//
+// TODO: 图片转发尚未完成. 上游 files 字段只接受先上传得到的文件 ID, 直接传入的图片地址与 base64
+// 会被忽略 (见 test/probe_vision.py), 而上传接口尚未确认. 接入上传后应把图片上传得到的 ID 放入 files,
+// 恢复模型的 vision 能力标记, 并只在所选模型不支持图片时返回此错误 (回退链跳过不支持图片的模型).
+// 在此之前不转发图片, 避免模型在看不到图片时凭空作答
+func visionNotSupported(model string) *apiError {
+	return &apiError{Status: http.StatusBadRequest, Type: "invalid_request_error", Code: "vision_not_supported",
+		Message: fmt.Sprintf("本服务暂不支持图片输入 (请求的模型: %s): 上游需要先上传图片, 上传尚未实现, 请仅发送文本", model)}
+}